@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/dulltz/megaconfigmap/pkg/cache"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func main() {
+	var socket = flag.String("socket", "/var/run/megaconfigmap/cache.sock", "Path of the unix socket to listen on. Empty disables it")
+	var listen = flag.String("listen", "", "TCP address to listen on with TLS, e.g. :8377 with a hostPort. Empty disables it")
+	var certFile = flag.String("tls-cert-file", "", "Path to the TLS certificate for --listen")
+	var keyFile = flag.String("tls-key-file", "", "Path to the TLS private key for --listen")
+	var cacheDir = flag.String("cache-dir", "/var/lib/megaconfigmap-cache", "Directory to cache combined megaconfigmaps")
+	var maxEntries = flag.Int("max-entries", 16, "Number of megaconfigmap versions to keep. Zero means unlimited")
+	flag.Parse()
+
+	if len(*socket) == 0 && len(*listen) == 0 {
+		log.Fatal("please specify --socket or --listen")
+	}
+	// callers send their service account tokens, which must not go over the network in plaintext
+	if len(*listen) > 0 && (len(*certFile) == 0 || len(*keyFile) == 0) {
+		log.Fatal("--listen requires --tls-cert-file and --tls-key-file")
+	}
+	err := os.MkdirAll(*cacheDir, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	k8s, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store := cache.NewStore(k8s, *cacheDir)
+	store.MaxEntries = *maxEntries
+	server := &http.Server{Handler: cache.NewServer(store)}
+
+	errCh := make(chan error, 2)
+	if len(*socket) > 0 {
+		err = os.MkdirAll(filepath.Dir(*socket), 0755)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = os.Remove(*socket)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+		listener, err := net.Listen("unix", *socket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// combiners run as arbitrary users, and are authorized by their service account tokens
+		err = os.Chmod(*socket, 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("starting megaconfigmap cache on", *socket)
+		go func() { errCh <- server.Serve(listener) }()
+	}
+	if len(*listen) > 0 {
+		listener, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("starting megaconfigmap cache on", *listen)
+		go func() { errCh <- server.ServeTLS(listener, *certFile, *keyFile) }()
+	}
+	log.Fatal(<-errCh)
+}
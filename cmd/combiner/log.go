@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+type fields map[string]interface{}
+
+// logger writes leveled log lines in plain text or JSON
+type logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+}
+
+func newLogger(out io.Writer, format string) (*logger, error) {
+	switch format {
+	case logFormatText, logFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log format %q; must be %s or %s", format, logFormatText, logFormatJSON)
+	}
+	return &logger{out: out, format: format}, nil
+}
+
+func (l *logger) Info(msg string, f fields) {
+	l.write("info", msg, f)
+}
+
+func (l *logger) Error(msg string, f fields) {
+	l.write("error", msg, f)
+}
+
+func (l *logger) write(level, msg string, f fields) {
+	now := time.Now().UTC()
+	var line string
+	if l.format == logFormatJSON {
+		entry := make(map[string]interface{}, len(f)+3)
+		for k, v := range f {
+			if d, ok := v.(time.Duration); ok {
+				v = d.Seconds()
+			}
+			entry[k] = v
+		}
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["level"] = level
+		entry["msg"] = msg
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"level":"error","msg":"failed to marshal log entry: %s"}`, err))
+		}
+		line = string(data)
+	} else {
+		keys := make([]string, 0, len(f))
+		for k := range f {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s %s", now.Format("2006/01/02 15:04:05"), level, msg)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, f[k])
+		}
+		line = b.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.out, line)
+}
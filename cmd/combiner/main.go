@@ -1,32 +1,189 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/events"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+const component = "megaconfigmap-combiner"
+
+// maxTerminationMessageBytes is the size limit of a termination message enforced by kubelet
+const maxTerminationMessageBytes = 4096
+
+var exitCodes = map[combiner.Reason]int{
+	combiner.ReasonUnknown:             1,
+	combiner.ReasonInvalidConfig:       2,
+	combiner.ReasonNotFound:            3,
+	combiner.ReasonForbidden:           4,
+	combiner.ReasonInvalidConfigMap:    5,
+	combiner.ReasonChecksumMismatch:    6,
+	combiner.ReasonIO:                  7,
+	combiner.ReasonAPI:                 8,
+	combiner.ReasonInsufficientStorage: 9,
+}
+
 func main() {
 	var megaConfigMapName = flag.String("megaconfigmap", "", "Name of the megaconfigmap")
 	var shareDir = flag.String("share-dir", "/data", "Path of the sharing directory among the pod")
+	var fileName = flag.String("file-name", "", "Name of the combined file in the share directory. The default is the megaconfigmap.io/filename label")
+	var fileMode = flag.String("file-mode", "", "Permission bits of the combined file in octal, e.g. 0644. The default is 0600")
+	var logFormat = flag.String("log-format", logFormatText, "Log format, text or json")
+	var terminationLog = flag.String("termination-log", "/dev/termination-log", "Path to write the termination message to. Empty disables it")
+	var recordEvents = flag.Bool("events", true, "Record Kubernetes Events on the pod and the megaconfigmap")
+	var podName = flag.String("pod-name", os.Getenv("POD_NAME"), "Name of the pod running the combiner, used as the subject of Events")
+	var podNamespace = flag.String("pod-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the pod running the combiner. The default is --namespace")
+	var podUID = flag.String("pod-uid", os.Getenv("POD_UID"), "UID of the pod running the combiner, used as the subject of Events")
+	var kubeconfig = flag.String("kubeconfig", "", "Path to the kubeconfig file. The in-cluster config is used by default")
+	var kubeContext = flag.String("context", "", "Name of the kubeconfig context to use")
+	var namespace = flag.String("namespace", "", "Namespace of the megaconfigmap. The default is the namespace of the pod or the kubeconfig context")
+	var skipIfCurrent = flag.Bool("skip-if-current", false, "Skip downloading if the share directory already holds the current megaconfigmap")
+	var verifyCached = flag.Bool("verify-cached", false, "Verify the digest of the file on disk before skipping")
+	var serve = flag.String("serve", "", "Serve the combined file over HTTP on this address, e.g. localhost:8080, instead of exiting")
+	var watchUpdates = flag.Bool("watch", false, "With --serve, combine and serve new versions of the megaconfigmap as it is updated")
+	var cacheEndpoint = flag.String("cache-endpoint", "", "Node-local cache to download from, unix:///PATH or https://HOST:PORT. The API server is used if it fails")
+	var cacheCAFile = flag.String("cache-ca-file", "", "CA certificates to verify an https cache endpoint. The default is the system roots")
+	var cacheServerName = flag.String("cache-server-name", "", "Name in the certificate of an https cache endpoint. The default is its host")
 	flag.Parse()
 
-	log.Println("megaconfigmap:", *megaConfigMapName)
-	log.Println("share-dir:", *shareDir)
+	logger, err := newLogger(os.Stderr, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCodes[combiner.ReasonInvalidConfig])
+	}
+	var notify func(eventType, reason, messageFmt string, args ...interface{})
+	fail := func(err error, f fields) {
+		reason := combiner.ReasonOf(err)
+		f["reason"] = reason
+		f["error"] = err.Error()
+		logger.Error("failed to combine megaconfigmap", f)
+		if notify != nil {
+			notify(corev1.EventTypeWarning, string(reason), "Failed to combine megaconfigmap %s after %s: %s",
+				*megaConfigMapName, f["duration"], err)
+		}
+		writeTerminationMessage(logger, *terminationLog, fmt.Sprintf("%s: %s", reason, err))
+		os.Exit(exitCodes[reason])
+	}
+
+	f := fields{"megaconfigmap": *megaConfigMapName, "share-dir": *shareDir}
+	logger.Info("starting combiner", f)
 	if len(*megaConfigMapName) == 0 {
-		log.Fatal("please specify --megaconfigmap")
+		fail(&combiner.Error{Reason: combiner.ReasonInvalidConfig, Err: errors.New("please specify --megaconfigmap")}, f)
 	}
 	if len(*shareDir) == 0 {
-		log.Fatal("please specify --share-dir")
+		fail(&combiner.Error{Reason: combiner.ReasonInvalidConfig, Err: errors.New("please specify --share-dir")}, f)
 	}
 
-	c, err := combiner.NewCombiner(*megaConfigMapName, *shareDir)
+	start := time.Now()
+	opts := []combiner.Option{
+		combiner.WithKubeconfig(*kubeconfig, *kubeContext),
+		combiner.WithNamespace(*namespace),
+		combiner.WithFileName(*fileName),
+	}
+	if len(*fileMode) > 0 {
+		mode, err := strconv.ParseUint(*fileMode, 8, 32)
+		if err != nil || mode > 0777 {
+			fail(&combiner.Error{Reason: combiner.ReasonInvalidConfig, Err: fmt.Errorf("invalid --file-mode %q", *fileMode)}, f)
+		}
+		opts = append(opts, combiner.WithFileMode(os.FileMode(mode)))
+	}
+	if *skipIfCurrent {
+		opts = append(opts, combiner.WithSkipIfCurrent(*verifyCached))
+	}
+	if len(*cacheEndpoint) > 0 {
+		opts = append(opts, combiner.WithCacheEndpoint(*cacheEndpoint), combiner.WithCacheTLS(*cacheCAFile, *cacheServerName))
+	}
+	c, err := combiner.NewCombiner(*megaConfigMapName, *shareDir, opts...)
+	if err != nil {
+		fail(err, f)
+	}
+	f["namespace"] = c.Namespace()
+	if *recordEvents {
+		if len(*podNamespace) == 0 {
+			*podNamespace = c.Namespace()
+		}
+		master := func() *corev1.ObjectReference {
+			return events.ConfigMapReference(c.Namespace(), *megaConfigMapName, c.MasterUID())
+		}
+		notify = newNotifier(logger, events.NewRecorder(c.Client(), component), master, *podNamespace, *podName, *podUID)
+	}
+	if len(*serve) > 0 {
+		runServer(logger, combiner.NewServer(c), *serve, *watchUpdates, f, fail, notify)
+		return
+	}
+	result, err := c.Run()
+	f["duration"] = time.Since(start)
 	if err != nil {
-		log.Fatal(err)
+		fail(err, f)
+	}
+	f["chunks"] = result.Chunks
+	f["bytes"] = result.Bytes
+	f["path"] = result.Path
+	if len(*cacheEndpoint) > 0 {
+		f["from-cache"] = result.FromCache
+	}
+	if result.CacheError != nil {
+		logger.Info("node-local cache is unavailable; fell back to the API server", fields{
+			"megaconfigmap": *megaConfigMapName, "cache-endpoint": *cacheEndpoint, "error": result.CacheError.Error()})
+	}
+	if result.Skipped {
+		logger.Info("megaconfigmap is up to date", f)
+		if notify != nil {
+			notify(corev1.EventTypeNormal, "UpToDate", "Skipped combining megaconfigmap %s: %s already holds it (%d bytes)",
+				*megaConfigMapName, result.Path, result.Bytes)
+		}
+		writeTerminationMessage(logger, *terminationLog, fmt.Sprintf("UpToDate: %s", result.Path))
+		return
+	}
+	logger.Info("combined megaconfigmap", f)
+	if notify != nil {
+		notify(corev1.EventTypeNormal, "Combined", "Combined megaconfigmap %s: %d chunks, %d bytes into %s in %s",
+			*megaConfigMapName, result.Chunks, result.Bytes, result.Path, f["duration"])
+	}
+	writeTerminationMessage(logger, *terminationLog,
+		fmt.Sprintf("Combined: %d chunks, %d bytes into %s", result.Chunks, result.Bytes, result.Path))
+}
+
+// newNotifier returns a function recording an event on both the pod and the megaconfigmap.
+// master is called for each event, since the UID of the megaconfigmap is known after the combiner reads it.
+func newNotifier(logger *logger, recorder *events.Recorder, master func() *corev1.ObjectReference, podNamespace, podName, podUID string) func(string, string, string, ...interface{}) {
+	var pod *corev1.ObjectReference
+	if len(podName) > 0 {
+		pod = events.PodReference(podNamespace, podName, types.UID(podUID))
+	}
+	return func(eventType, reason, messageFmt string, args ...interface{}) {
+		refs := []*corev1.ObjectReference{master()}
+		if pod != nil {
+			refs = append(refs, pod)
+		}
+		for _, ref := range refs {
+			err := recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+			if err != nil {
+				logger.Error("failed to record event", fields{"kind": ref.Kind, "name": ref.Name, "error": err.Error()})
+			}
+		}
+	}
+}
+
+// writeTerminationMessage writes msg to path so that it is shown by kubectl describe pod
+func writeTerminationMessage(logger *logger, path, msg string) {
+	if len(path) == 0 {
+		return
+	}
+	if len(msg) > maxTerminationMessageBytes {
+		msg = msg[:maxTerminationMessageBytes]
 	}
-	err = c.Run()
+	err := ioutil.WriteFile(path, []byte(msg), 0644)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("failed to write termination message", fields{"path": path, "error": err.Error()})
 	}
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// runServer serves the combined file on addr until the HTTP server fails.
+// The server starts before the first combination so that liveness probes succeed while downloading.
+func runServer(logger *logger, server *combiner.Server, addr string, watch bool, f fields,
+	fail func(error, fields), notify func(string, string, string, ...interface{})) {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- http.ListenAndServe(addr, server)
+	}()
+	logger.Info("starting HTTP server", fields{"megaconfigmap": f["megaconfigmap"], "address": addr})
+
+	name, namespace := f["megaconfigmap"], f["namespace"]
+	onSync := func(result *combiner.Result, err error) {
+		sf := fields{"megaconfigmap": name, "namespace": namespace}
+		if err != nil {
+			sf["reason"] = combiner.ReasonOf(err)
+			sf["error"] = err.Error()
+			logger.Error("failed to combine megaconfigmap; serving the previous version", sf)
+			if notify != nil {
+				notify(corev1.EventTypeWarning, string(combiner.ReasonOf(err)), "Failed to combine megaconfigmap %s: %s", name, err)
+			}
+			return
+		}
+		sf["id"] = result.ID
+		sf["chunks"] = result.Chunks
+		sf["bytes"] = result.Bytes
+		logger.Info("serving megaconfigmap version", sf)
+		if notify != nil {
+			notify(corev1.EventTypeNormal, "Combined", "Combined megaconfigmap %s: %d chunks, %d bytes, serving on %s",
+				name, result.Chunks, result.Bytes, addr)
+		}
+	}
+
+	start := time.Now()
+	result, err := server.Sync()
+	f["duration"] = time.Since(start)
+	if err != nil {
+		fail(err, f)
+	}
+	onSync(result, nil)
+
+	if watch {
+		go server.Watch(context.Background(), onSync)
+	}
+	err = <-errCh
+	fail(&combiner.Error{Reason: combiner.ReasonIO, Err: err}, f)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/controller"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func main() {
+	var kubeconfig = flag.String("kubeconfig", "", "Path to the kubeconfig file. The in-cluster config is used by default")
+	var namespace = flag.String("namespace", "", "Namespace to watch. All namespaces are watched by default")
+	var workers = flag.Int("workers", 2, "Number of concurrent reconciliations")
+	var resync = flag.Duration("resync", 10*time.Minute, "Interval to reconcile all megaconfigmaps again")
+	flag.Parse()
+
+	config, err := loadConfig(*kubeconfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	k8s, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
+
+	log.Println("starting megaconfigmap controller")
+	c := controller.NewController(k8s, dynamicClient, *namespace, *resync)
+	err = c.Run(*workers, stopCh)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadConfig(kubeconfig string) (*rest.Config, error) {
+	if len(kubeconfig) > 0 {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	mcmcsi "github.com/dulltz/megaconfigmap/pkg/csi"
+	"google.golang.org/grpc"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var version = "dev"
+
+func main() {
+	var endpoint = flag.String("endpoint", "unix:///csi/csi.sock", "CSI endpoint")
+	var nodeName = flag.String("node-name", os.Getenv("NODE_NAME"), "Name of the node")
+	var cacheDir = flag.String("cache-dir", "/var/lib/megaconfigmap-csi", "Directory to cache combined megaconfigmaps")
+	flag.Parse()
+
+	if len(*nodeName) == 0 {
+		log.Fatal("please specify --node-name")
+	}
+	err := os.MkdirAll(*cacheDir, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	k8s, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	u, err := url.Parse(*endpoint)
+	if err != nil || u.Scheme != "unix" {
+		log.Fatalf("invalid endpoint %s; must be unix:///path", *endpoint)
+	}
+	err = os.Remove(u.Path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	listener, err := net.Listen("unix", u.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	driver := mcmcsi.NewDriver(k8s, *nodeName, version, *cacheDir, mcmcsi.NewMounter())
+	server := grpc.NewServer(grpc.UnaryInterceptor(logErrors))
+	csi.RegisterIdentityServer(server, driver)
+	csi.RegisterNodeServer(server, driver)
+	log.Println("starting megaconfigmap CSI driver on", *endpoint)
+	log.Fatal(server.Serve(listener))
+}
+
+func logErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Printf("%s failed: %v", info.FullMethod, err)
+	}
+	return resp, err
+}
@@ -18,6 +18,13 @@ func main() {
 		panic(err)
 	}
 	root.AddCommand(megaconfigmap.NewCmdCreate(streams))
+	root.AddCommand(megaconfigmap.NewCmdUpdate(streams))
+	root.AddCommand(megaconfigmap.NewCmdPlan(streams))
+	root.AddCommand(megaconfigmap.NewCmdDelete(streams))
+	root.AddCommand(megaconfigmap.NewCmdPostRender(streams))
+	root.AddCommand(megaconfigmap.NewCmdInject(streams))
+	root.AddCommand(megaconfigmap.NewCmdRBAC(streams))
+	root.AddCommand(megaconfigmap.NewCmdDoctor(streams))
 	if err := root.Execute(); err != nil {
 		os.Exit(1)
 	}
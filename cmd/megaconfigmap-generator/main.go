@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dulltz/megaconfigmap/pkg/generator"
+)
+
+// main runs the kustomize KRM function generating megaconfigmaps.
+// It reads a ResourceList from stdin and writes it to stdout.
+func main() {
+	if err := generator.Run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
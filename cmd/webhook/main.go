@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	"github.com/dulltz/megaconfigmap/pkg/webhook"
+	"sigs.k8s.io/yaml"
+)
+
+func main() {
+	var listen = flag.String("listen", ":8443", "Address to listen on")
+	var certFile = flag.String("tls-cert-file", "/etc/webhook/tls.crt", "Path to the TLS certificate")
+	var keyFile = flag.String("tls-key-file", "/etc/webhook/tls.key", "Path to the TLS private key")
+	var configFile = flag.String("config", "", "Path to the YAML file configuring the injected combiner")
+	var fieldManagers = flag.String("allowed-field-managers", webhook.DefaultFieldManagers, "Comma separated field managers allowed to modify megaconfigmaps. Clients choose them, so they only guard against accidents")
+	var users = flag.String("allowed-users", webhook.DefaultUsers, "Comma separated users allowed to modify and delete megaconfigmaps")
+	flag.Parse()
+
+	cfg := &inject.Config{}
+	if len(*configFile) > 0 {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = yaml.UnmarshalStrict(data, cfg)
+		if err != nil {
+			log.Fatalf("failed to parse %s: %v", *configFile, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", &webhook.Mutator{Config: cfg})
+	mux.Handle("/validate", &webhook.Validator{
+		FieldManagers: toSet(*fieldManagers),
+		Users:         toSet(*users),
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	log.Println("starting megaconfigmap webhook on", *listen)
+	log.Fatal(http.ListenAndServeTLS(*listen, *certFile, *keyFile, mux))
+}
+
+func toSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			set[item] = true
+		}
+	}
+	return set
+}
@@ -50,7 +50,7 @@ var _ = Describe("Combiner", func() {
 						return fmt.Errorf("failed to unmarshal. err: %s", err)
 					}
 					if len(cml.Items) > 0 {
-						return fmt.Errorf(string(len(cml.Items)) + " configmap remains")
+						return fmt.Errorf("%d configmap remains", len(cml.Items))
 					}
 					return nil
 				}, 20*time.Second).ShouldNot(HaveOccurred())
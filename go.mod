@@ -3,13 +3,15 @@ module github.com/dulltz/megaconfigmap
 go 1.13
 
 require (
+	github.com/container-storage-interface/spec v1.2.0
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/pflag v1.0.5
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	google.golang.org/grpc v1.26.0
 	k8s.io/api v0.17.16
 	k8s.io/apimachinery v0.17.16
 	k8s.io/cli-runtime v0.17.0
 	k8s.io/client-go v0.17.16
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,93 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto copies m into out
+func (m *MegaConfigMap) DeepCopyInto(out *MegaConfigMap) {
+	*out = *m
+	out.TypeMeta = m.TypeMeta
+	m.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	m.Spec.DeepCopyInto(&out.Spec)
+	m.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy copies m
+func (m *MegaConfigMap) DeepCopy() *MegaConfigMap {
+	if m == nil {
+		return nil
+	}
+	out := new(MegaConfigMap)
+	m.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (m *MegaConfigMap) DeepCopyObject() runtime.Object {
+	return m.DeepCopy()
+}
+
+// DeepCopyInto copies s into out
+func (s *MegaConfigMapSpec) DeepCopyInto(out *MegaConfigMapSpec) {
+	*out = *s
+	if s.Chunks != nil {
+		out.Chunks = make([]Chunk, len(s.Chunks))
+		copy(out.Chunks, s.Chunks)
+	}
+}
+
+// DeepCopyInto copies s into out
+func (s *MegaConfigMapStatus) DeepCopyInto(out *MegaConfigMapStatus) {
+	*out = *s
+	if s.Conditions != nil {
+		out.Conditions = make([]Condition, len(s.Conditions))
+		for i := range s.Conditions {
+			s.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+}
+
+// DeepCopy copies s
+func (s *MegaConfigMapStatus) DeepCopy() *MegaConfigMapStatus {
+	if s == nil {
+		return nil
+	}
+	out := new(MegaConfigMapStatus)
+	s.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies c into out
+func (c *Condition) DeepCopyInto(out *Condition) {
+	*out = *c
+	c.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopyInto copies l into out
+func (l *MegaConfigMapList) DeepCopyInto(out *MegaConfigMapList) {
+	*out = *l
+	out.TypeMeta = l.TypeMeta
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]MegaConfigMap, len(l.Items))
+		for i := range l.Items {
+			l.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+// DeepCopy copies l
+func (l *MegaConfigMapList) DeepCopy() *MegaConfigMapList {
+	if l == nil {
+		return nil
+	}
+	out := new(MegaConfigMapList)
+	l.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (l *MegaConfigMapList) DeepCopyObject() runtime.Object {
+	return l.DeepCopy()
+}
@@ -0,0 +1,97 @@
+// Package v1alpha1 contains the MegaConfigMap custom resource.
+package v1alpha1
+
+import (
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of MegaConfigMap
+	GroupName = "megaconfigmap.io"
+	// Kind is the kind of MegaConfigMap
+	Kind = "MegaConfigMap"
+)
+
+var (
+	// SchemeGroupVersion is the group version of this package
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+	// Resource is the resource of MegaConfigMap
+	Resource = SchemeGroupVersion.WithResource("megaconfigmaps")
+)
+
+// FromUnstructured converts u to a MegaConfigMap
+func FromUnstructured(u *unstructured.Unstructured) (*MegaConfigMap, error) {
+	var m MegaConfigMap
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ToUnstructured converts m to an Unstructured object
+func ToUnstructured(m *MegaConfigMap) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(m)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetAPIVersion(SchemeGroupVersion.String())
+	u.SetKind(Kind)
+	return u, nil
+}
+
+// SpecFromMaster builds a MegaConfigMapSpec from the master configmap and its manifest
+func SpecFromMaster(master *corev1.ConfigMap, manifest *combiner.Manifest) MegaConfigMapSpec {
+	spec := MegaConfigMapSpec{
+		ID:       master.Labels[combiner.IDLabel],
+		FileName: master.Labels[combiner.FileNameLabel],
+		Bytes:    manifest.Bytes,
+		SHA256:   manifest.SHA256,
+		Chunks:   make([]Chunk, len(manifest.Chunks)),
+	}
+	for i, c := range manifest.Chunks {
+		spec.Chunks[i] = Chunk(c)
+	}
+	return spec
+}
+
+// SetCondition adds or updates the condition of typ.
+// The transition time is updated only when the status changes.
+func (s *MegaConfigMapStatus) SetCondition(typ string, status metav1.ConditionStatus, reason, message string) {
+	for i := range s.Conditions {
+		c := &s.Conditions[i]
+		if c.Type != typ {
+			continue
+		}
+		if c.Status != status {
+			c.LastTransitionTime = metav1.Now()
+		}
+		c.Status = status
+		c.Reason = reason
+		c.Message = message
+		return
+	}
+	s.Conditions = append(s.Conditions, Condition{
+		Type:               typ,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// Condition returns the condition of typ, or nil if it is not found
+func (s *MegaConfigMapStatus) Condition(typ string) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == typ {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phase is the lifecycle phase of a MegaConfigMap
+type Phase string
+
+const (
+	// PhasePending means the partial configmaps are being uploaded
+	PhasePending Phase = "Pending"
+	// PhaseReady means all partial configmaps exist and match their digests
+	PhaseReady Phase = "Ready"
+	// PhaseDegraded means some partial configmaps are missing or corrupted
+	PhaseDegraded Phase = "Degraded"
+)
+
+const (
+	// ConditionMasterFound indicates the master configmap of the MegaConfigMap exists
+	ConditionMasterFound = "MasterFound"
+	// ConditionChunksReady indicates all partial configmaps exist and match their digests
+	ConditionChunksReady = "ChunksReady"
+	// ConditionOwnerReferencesValid indicates all partial configmaps are owned by the master configmap
+	ConditionOwnerReferencesValid = "OwnerReferencesValid"
+)
+
+// MegaConfigMap is a configmap larger than 1MB stored in a master configmap and its partial configmaps
+type MegaConfigMap struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MegaConfigMapSpec   `json:"spec"`
+	Status MegaConfigMapStatus `json:"status,omitempty"`
+}
+
+// MegaConfigMapSpec describes the content of a MegaConfigMap
+type MegaConfigMapSpec struct {
+	// ID is the megaconfigmap.io/id of the master configmap
+	ID string `json:"id"`
+	// FileName is the name of the combined file
+	FileName string `json:"fileName"`
+	// Bytes is the size of the combined file
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex digest of the combined file
+	SHA256 string `json:"sha256"`
+	// Chunks lists the partial configmaps in order
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk describes a partial configmap
+type Chunk struct {
+	// Name is the name of the partial configmap
+	Name string `json:"name"`
+	// Bytes is the size of the partial data
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex digest of the partial data
+	SHA256 string `json:"sha256"`
+}
+
+// MegaConfigMapStatus reports the observed state of a MegaConfigMap
+type MegaConfigMapStatus struct {
+	// Phase is the lifecycle phase
+	Phase Phase `json:"phase,omitempty"`
+	// ObservedGeneration is the generation of the spec last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ObservedID is the ID of the content last reconciled
+	ObservedID string `json:"observedID,omitempty"`
+	// Chunks is the number of partial configmaps in the spec
+	Chunks int `json:"chunks"`
+	// ReadyChunks is the number of partial configmaps that exist and match their digests
+	ReadyChunks int `json:"readyChunks"`
+	// TotalBytes is the size of the combined file
+	TotalBytes int64 `json:"totalBytes"`
+	// Conditions are the latest observations
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Condition is an observation of an aspect of a MegaConfigMap
+type Condition struct {
+	// Type is the type of the condition
+	Type string `json:"type"`
+	// Status is True, False or Unknown
+	Status metav1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the status changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// Reason is a CamelCase reason for the last transition
+	Reason string `json:"reason"`
+	// Message is a human readable description of the last transition
+	Message string `json:"message"`
+}
+
+// MegaConfigMapList is a list of MegaConfigMap
+type MegaConfigMapList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []MegaConfigMap `json:"items"`
+}
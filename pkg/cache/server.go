@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reviewTTL is how long an allowed access review is reused for the same token and megaconfigmap
+const reviewTTL = time.Minute
+
+// Server serves megaconfigmaps in a Store to combiners on the same node.
+//
+// GET /v1/namespaces/NAMESPACE/megaconfigmaps/NAME/ID responds the combined file if ID is the current version.
+// Callers present the token of their service account as a bearer token, and must be allowed to get the megaconfigmap.
+// Nothing is fetched for the cache until the caller is authorized and ID is the ID of the megaconfigmap.
+type Server struct {
+	store *Store
+
+	mu      sync.Mutex
+	allowed map[string]time.Time
+	now     func() time.Time
+}
+
+// NewServer creates a Server
+func NewServer(store *Store) *Server {
+	return &Server{store: store, allowed: make(map[string]time.Time), now: time.Now}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	namespace, name, id, ok := parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if code, err := s.authorize(r, namespace, name); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	master, err := s.store.k8s.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	if current := master.Labels[combiner.IDLabel]; current != id {
+		http.Error(w, fmt.Sprintf("megaconfigmap %s/%s is at %s", namespace, name, current), http.StatusConflict)
+		return
+	}
+	entry, err := s.store.Assemble(namespace, name)
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	if entry.ID != id {
+		http.Error(w, fmt.Sprintf("megaconfigmap %s/%s is at %s", namespace, name, entry.ID), http.StatusConflict)
+		return
+	}
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", `"`+entry.ID+`"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// authorize checks that the bearer token of r is allowed to get the megaconfigmap namespace/name by a TokenReview
+// and a SubjectAccessReview. It returns the HTTP status code to respond if not.
+func (s *Server) authorize(r *http.Request, namespace, name string) (int, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 || token == r.Header.Get("Authorization") {
+		return http.StatusUnauthorized, errors.New("bearer token is required")
+	}
+	key := fmt.Sprintf("%x/%s/%s", sha256.Sum256([]byte(token)), namespace, name)
+	s.mu.Lock()
+	expiry, ok := s.allowed[key]
+	s.mu.Unlock()
+	if ok && s.now().Before(expiry) {
+		return http.StatusOK, nil
+	}
+
+	review, err := s.store.k8s.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, errors.New("token is not authenticated")
+	}
+	user := review.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access, err := s.store.k8s.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Resource:  "configmaps",
+				Name:      name,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+		},
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("%s cannot get configmap %s in %s", user.Username, name, namespace)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, expiry := range s.allowed {
+		if !now.Before(expiry) {
+			delete(s.allowed, k)
+		}
+	}
+	s.allowed[key] = now.Add(reviewTTL)
+	return http.StatusOK, nil
+}
+
+func parsePath(path string) (namespace, name, id string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 6 || parts[0] != "v1" || parts[1] != "namespaces" || parts[3] != "megaconfigmaps" {
+		return "", "", "", false
+	}
+	for _, p := range parts {
+		if len(p) == 0 {
+			return "", "", "", false
+		}
+	}
+	return parts[2], parts[4], parts[5], true
+}
+
+func statusCode(err error) int {
+	switch combiner.ReasonOf(err) {
+	case combiner.ReasonNotFound:
+		return http.StatusNotFound
+	case combiner.ReasonForbidden:
+		return http.StatusForbidden
+	case combiner.ReasonInvalidConfigMap, combiner.ReasonChecksumMismatch:
+		return http.StatusBadGateway
+	case combiner.ReasonInsufficientStorage:
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
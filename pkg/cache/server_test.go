@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServer(t *testing.T) {
+	data := "abcdefg"
+	id := combiner.MapID([]byte(data), "default", "my-conf")
+	cluster := megaconfigmaptest.New(t)
+	cluster.Put(megaconfigmaptest.LegacyConfigMaps("default", "my-conf", []byte(data), len(data))...)
+	k8s := cluster.Fake()
+	// my-token is of a service account allowed to get my-conf, and other-token is of one that is not
+	k8s.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview).DeepCopy()
+		switch review.Spec.Token {
+		case "my-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "system:serviceaccount:default:my-app"}}
+		case "other-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "system:serviceaccount:other:other-app"}}
+		}
+		return true, review, nil
+	})
+	k8s.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview).DeepCopy()
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "system:serviceaccount:default:my-app" &&
+			attrs.Namespace == "default" && attrs.Verb == "get" && attrs.Resource == "configmaps"
+		return true, review, nil
+	})
+	dir, err := ioutil.TempDir("", "megaconfigmap-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(NewServer(NewStore(k8s, dir)))
+	defer server.Close()
+
+	token := "my-token"
+	get := func(path string) (int, string, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body), resp.Header.Get("ETag")
+	}
+
+	code, body, etag := get(combiner.CachePath("default", "my-conf", id))
+	if code != http.StatusOK || body != data || etag != `"`+id+`"` {
+		t.Errorf("unexpected response: %d %q %s", code, body, etag)
+	}
+
+	// the second request is served from the disk
+	err = k8s.CoreV1().ConfigMaps("default").Delete("my-conf-0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, body, _ = get(combiner.CachePath("default", "my-conf", id))
+	if code != http.StatusOK || body != data {
+		t.Errorf("unexpected response from the cache: %d %q", code, body)
+	}
+
+	// a stale id is rejected before fetching any partial configmap
+	k8s.ClearActions()
+	code, _, _ = get(combiner.CachePath("default", "my-conf", "stale"))
+	if code != http.StatusConflict {
+		t.Errorf("status for a stale id = %d, want %d", code, http.StatusConflict)
+	}
+	for _, action := range k8s.Actions() {
+		if get, ok := action.(k8stesting.GetAction); ok && get.GetName() != "my-conf" {
+			t.Errorf("%s is fetched for a stale id", get.GetName())
+		}
+	}
+	code, _, _ = get(combiner.CachePath("default", "missing", id))
+	if code != http.StatusNotFound {
+		t.Errorf("status for a missing megaconfigmap = %d, want %d", code, http.StatusNotFound)
+	}
+	code, _, _ = get("/v1/namespaces/default/megaconfigmaps/my-conf")
+	if code != http.StatusNotFound {
+		t.Errorf("status for an invalid path = %d, want %d", code, http.StatusNotFound)
+	}
+
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{token: "", want: http.StatusUnauthorized},
+		{token: "invalid-token", want: http.StatusUnauthorized},
+		{token: "other-token", want: http.StatusForbidden},
+	} {
+		token = tt.token
+		k8s.ClearActions()
+		code, _, _ = get(combiner.CachePath("default", "my-conf", id))
+		if code != tt.want {
+			t.Errorf("status for token %q = %d, want %d", tt.token, code, tt.want)
+		}
+		for _, action := range k8s.Actions() {
+			if action.GetResource().Resource == "configmaps" {
+				t.Errorf("configmaps are read for token %q", tt.token)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stagingPrefix is the prefix of directories being combined. Names starting with "." are not entries.
+const stagingPrefix = ".staging-"
+
+// Entry is a combined megaconfigmap in a Store
+type Entry struct {
+	// ID is the megaconfigmap ID
+	ID string
+	// Dir is the directory holding the combined file
+	Dir string
+	// Path is the path of the combined file
+	Path string
+}
+
+// Store combines megaconfigmaps into a directory per ID, so that each version is fetched from the API server only once.
+type Store struct {
+	k8s kubernetes.Interface
+	dir string
+
+	// MaxEntries is the number of versions kept in the store. Zero means unlimited.
+	// Least recently used versions are removed first, so it must be zero if entries are bind-mounted.
+	MaxEntries int
+
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+// idLock serializes the work on an ID. refs counts the holder and waiters, and the lock is dropped when it is 0,
+// so that the locks of removed entries do not pile up.
+type idLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewStore creates a Store in dir
+func NewStore(k8s kubernetes.Interface, dir string) *Store {
+	return &Store{
+		k8s:   k8s,
+		dir:   dir,
+		locks: make(map[string]*idLock),
+	}
+}
+
+// Assemble returns the entry holding the current content of the megaconfigmap namespace/name.
+// Concurrent calls for the same version wait for the first one instead of fetching it again.
+func (s *Store) Assemble(namespace, name string) (*Entry, error) {
+	entry, err := s.assemble(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	s.prune()
+	return entry, nil
+}
+
+func (s *Store) assemble(namespace, name string) (*Entry, error) {
+	master, err := s.k8s.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	id := master.Labels[combiner.IDLabel]
+	fileName := master.Labels[combiner.FileNameLabel]
+	if len(id) == 0 || len(fileName) == 0 {
+		return nil, &combiner.Error{Reason: combiner.ReasonInvalidConfigMap, Err: fmt.Errorf("configmap %s is not a megaconfigmap", name)}
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+	if entry := s.lookup(id, fileName); entry != nil {
+		return entry, nil
+	}
+
+	staging, err := ioutil.TempDir(s.dir, stagingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	// Entries are shared read-only with pods of any user, as the CSI driver bind mounts them
+	c, err := combiner.NewCombiner(name, staging, combiner.WithClient(s.k8s), combiner.WithNamespace(namespace),
+		combiner.WithFileMode(0444))
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.Run()
+	if err != nil {
+		return nil, err
+	}
+	// The megaconfigmap may be updated after it was read above
+	fileName = filepath.Base(result.Path)
+	if entry := s.lookup(result.ID, fileName); entry != nil {
+		return entry, nil
+	}
+	err = os.Chmod(staging, 0755)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.dir, result.ID)
+	err = os.Rename(staging, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{ID: result.ID, Dir: dir, Path: filepath.Join(dir, fileName)}, nil
+}
+
+// lookup returns the entry of id if it exists, and marks it as recently used
+func (s *Store) lookup(id, fileName string) *Entry {
+	dir := filepath.Join(s.dir, id)
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return &Entry{ID: id, Dir: dir, Path: filepath.Join(dir, fileName)}
+}
+
+// Remove removes the versions for which keep returns false
+func (s *Store) Remove(keep func(id string) bool) error {
+	entries, err := s.entries()
+	if err != nil {
+		return err
+	}
+	for _, info := range entries {
+		if !keep(info.Name()) {
+			s.remove(info.Name())
+		}
+	}
+	return nil
+}
+
+// prune removes least recently used entries exceeding MaxEntries
+func (s *Store) prune() {
+	if s.MaxEntries <= 0 {
+		return
+	}
+	entries, err := s.entries()
+	if err != nil || len(entries) <= s.MaxEntries {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	for _, info := range entries[:len(entries)-s.MaxEntries] {
+		s.remove(info.Name())
+	}
+}
+
+func (s *Store) entries() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []os.FileInfo
+	for _, info := range infos {
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			entries = append(entries, info)
+		}
+	}
+	return entries, nil
+}
+
+func (s *Store) remove(id string) {
+	unlock := s.lock(id)
+	os.RemoveAll(filepath.Join(s.dir, id))
+	unlock()
+}
+
+// lock locks id and returns the function to unlock it
+func (s *Store) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &idLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+)
+
+func TestStore_locks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster := megaconfigmaptest.New(t)
+	s := NewStore(cluster.Client(), dir)
+	s.MaxEntries = 1
+	for _, data := range []string{"abcdefg", "hijklmn", "opqrstu"} {
+		cluster.Put(megaconfigmaptest.ConfigMaps("default", "my-conf", []byte(data), 3)...)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.Assemble("default", "my-conf"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	entries, err := s.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries are kept, want 1", len(entries))
+	}
+	if len(s.locks) != 0 {
+		t.Errorf("%d locks are left", len(s.locks))
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// ObjectLimitBytes is the maximum size of the data of a configmap. The API server counts binaryData after decoding.
+	ObjectLimitBytes = int64(1024 * 1024)
+	// MetadataOverheadBytes is reserved in a configmap for its name, labels and owner reference
+	MetadataOverheadBytes = int64(8 * 1024)
+	// MaxChunkSize is the maximum size of a chunk, which fits in a partial configmap with its metadata
+	MaxChunkSize = ObjectLimitBytes - MetadataOverheadBytes
+	// MaxManifestSize is the maximum size of a manifest, which fits in the megaconfigmap with its metadata
+	MaxManifestSize = ObjectLimitBytes - MetadataOverheadBytes
+	// MaxEncodedTextBytes is the maximum size of a chunk stored in data after escaping it as a JSON string.
+	// It keeps the request body of a partial configmap far below the 3MiB limit of the API server.
+	MaxEncodedTextBytes = 2 * ObjectLimitBytes
+	// MinChunkSize is the minimum chunk size accepted by ValidateChunkSize, since smaller chunks make too many partial configmaps
+	MinChunkSize = int64(64 * 1024)
+)
+
+// ValidateChunkSize checks a chunk size given by users. DefaultChunkSize is valid.
+func ValidateChunkSize(bytes int64) error {
+	switch {
+	case bytes == DefaultChunkSize:
+	case bytes < MinChunkSize:
+		return fmt.Errorf("block size %d is smaller than %d, which makes too many partial configmaps", bytes, MinChunkSize)
+	case bytes > MaxChunkSize:
+		return fmt.Errorf("block size %d is larger than %d, which fits in a configmap of %d bytes with metadata",
+			bytes, MaxChunkSize, ObjectLimitBytes)
+	}
+	return nil
+}
+
+// split splits stored into chunks of the chunk size of c.
+// With DefaultChunkSize, each chunk is as large as a partial configmap holds, which minimizes their number.
+func (c *Client) split(stored []byte) [][]byte {
+	var chunks [][]byte
+	for start := 0; start < len(stored); {
+		size := int(c.chunkSize)
+		if size == 0 {
+			size = autoChunkSize(stored[start:])
+		}
+		end := start + size
+		if end > len(stored) {
+			end = len(stored)
+		}
+		chunks = append(chunks, stored[start:end])
+		start = end
+	}
+	return chunks
+}
+
+// autoChunkSize returns the size of the next chunk of rest: MaxChunkSize bytes, ending at a character boundary
+// if they are text so that the chunk stays text
+func autoChunkSize(rest []byte) int {
+	if len(rest) <= int(MaxChunkSize) {
+		return len(rest)
+	}
+	end := int(MaxChunkSize)
+	for i := 0; i < utf8.UTFMax && !utf8.RuneStart(rest[end]); i++ {
+		end--
+	}
+	if isText(rest[:end]) {
+		return end
+	}
+	return int(MaxChunkSize)
+}
+
+// isText returns true if chunk is stored in data as is, which requires valid UTF-8.
+// Chunks whose escaping as a JSON string exceeds MaxEncodedTextBytes, e.g. with many control characters, are stored in binaryData.
+func isText(chunk []byte) bool {
+	return utf8.Valid(chunk) && escapedSize(chunk) <= MaxEncodedTextBytes
+}
+
+// escapedSize returns the size of valid UTF-8 s escaped as a JSON string by the API machinery, which also escapes HTML characters
+func escapedSize(s []byte) int64 {
+	var n int64
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		switch {
+		case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+			n += 2
+		case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+			n += 6
+		default:
+			n += int64(size)
+		}
+		i += size
+	}
+	return n
+}
+
+// checkManifestSize returns an error if the manifest of chunks does not fit in the megaconfigmap
+func checkManifestSize(manifestData string, chunks int) error {
+	if int64(len(manifestData)) > MaxManifestSize {
+		return fmt.Errorf("the manifest of %d partial configmaps is %d bytes, larger than %d; use a larger block size",
+			chunks, len(manifestData), MaxManifestSize)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateChunkSize(t *testing.T) {
+	tests := []struct {
+		bytes   int64
+		wantErr bool
+	}{
+		{bytes: DefaultChunkSize},
+		{bytes: MinChunkSize},
+		{bytes: MaxChunkSize},
+		{bytes: 4, wantErr: true},
+		{bytes: -1, wantErr: true},
+		{bytes: MaxChunkSize + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateChunkSize(tt.bytes); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateChunkSize(%d) error = %v, wantErr %v", tt.bytes, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClient_split(t *testing.T) {
+	// "あ" is 3 bytes, so MaxChunkSize is not at a character boundary
+	text := bytes.Repeat([]byte("あ"), int(MaxChunkSize)/3+100)
+	binary := bytes.Repeat([]byte{0xff}, int(MaxChunkSize)*2+1)
+	// NUL is valid UTF-8, but is escaped to 6 bytes in JSON
+	control := make([]byte, MaxChunkSize*2)
+	tests := []struct {
+		name       string
+		data       []byte
+		wantChunks int
+		wantText   bool
+	}{
+		{name: "small", data: []byte("abc"), wantChunks: 1, wantText: true},
+		{name: "text", data: text, wantChunks: 2, wantText: true},
+		{name: "binary", data: binary, wantChunks: 3},
+		{name: "control characters", data: control, wantChunks: 2},
+		{name: "empty", data: []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(fake.NewSimpleClientset())
+			chunks := c.split(tt.data)
+			if len(chunks) != tt.wantChunks {
+				t.Fatalf("got %d chunks, want %d", len(chunks), tt.wantChunks)
+			}
+			if !bytes.Equal(bytes.Join(chunks, nil), tt.data) {
+				t.Error("chunks do not make up the data")
+			}
+			for i, chunk := range chunks {
+				if int64(len(chunk)) > MaxChunkSize {
+					t.Errorf("chunk %d is %d bytes, more than %d", i, len(chunk), MaxChunkSize)
+				}
+				if isText(chunk) != tt.wantText {
+					t.Errorf("chunk %d is text = %v, want %v", i, isText(chunk), tt.wantText)
+				}
+			}
+
+			ctx := context.Background()
+			if _, err := c.Create(ctx, "default", "my-conf", "conf.txt", tt.data); err != nil {
+				t.Fatal(err)
+			}
+			r, err := c.Open(ctx, "default", "my-conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Error("combined data differs")
+			}
+		})
+	}
+}
+
+func TestEscapedSize(t *testing.T) {
+	for _, s := range []string{"abc", "あいう", "a\"b\\c\n", "<a href=\"x\">&</a>", "\x00\x1f", "\u2028"} {
+		encoded, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// encoding/json may escape some control characters shorter than the API machinery
+		if got, want := escapedSize([]byte(s)), int64(len(encoded)-2); got < want {
+			t.Errorf("escapedSize(%q) = %d, less than %d", s, got, want)
+		}
+	}
+	if got := escapedSize([]byte("abc")); got != 3 {
+		t.Errorf("escapedSize(abc) = %d, want 3", got)
+	}
+}
+
+func TestClient_Create_manifestTooLarge(t *testing.T) {
+	k8s := fake.NewSimpleClientset()
+	c := New(k8s, WithChunkSize(1))
+	data := bytes.Repeat([]byte("a"), 20000)
+	if _, err := c.Plan("default", "my-conf", data); err == nil {
+		t.Error("Plan() should fail")
+	}
+	if _, err := c.Create(context.Background(), "default", "my-conf", "conf.txt", data); err == nil {
+		t.Error("Create() should fail")
+	}
+	if n := len(k8s.Actions()); n > 0 {
+		t.Errorf("%d requests are sent", n)
+	}
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/events"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultChunkSize means the sizes of the data in partial configmaps are chosen automatically
+	DefaultChunkSize = int64(0)
+	// DefaultParallelism is the default number of partial configmaps written concurrently
+	DefaultParallelism = 8
+	// FieldManager is the field manager that the validating webhook allows to modify megaconfigmaps
+	FieldManager = "kubectl-megaconfigmap"
+)
+
+// MegaConfigMap is a megaconfigmap stored in the cluster
+type MegaConfigMap struct {
+	// Namespace is the namespace of the megaconfigmap
+	Namespace string
+	// Name is the name of the megaconfigmap
+	Name string
+	// ID is the megaconfigmap ID of the content
+	ID string
+	// FileName is the name of the combined file
+	FileName string
+	// Manifest describes the partial configmaps. It is nil for megaconfigmaps created by older versions.
+	Manifest *combiner.Manifest
+	// Pending means the upload of the partial configmaps is not complete. Create with the same content resumes it.
+	Pending bool
+	// ConfigMap is the master configmap
+	ConfigMap *corev1.ConfigMap
+}
+
+// Client creates, reads and deletes megaconfigmaps
+type Client struct {
+	k8s         kubernetes.Interface
+	chunkSize   int64
+	encoding    string
+	parallelism int
+	recorder    *events.Recorder
+	progress    func(Progress)
+
+	retries       int
+	retryInterval time.Duration
+	// after is replaced by tests to skip the backoff
+	after func(time.Duration) <-chan time.Time
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithChunkSize sets the size of the data in a partial configmap.
+// The default DefaultChunkSize makes each partial configmap as large as fits in a configmap; see MaxChunkSize.
+func WithChunkSize(bytes int64) Option {
+	return func(c *Client) {
+		c.chunkSize = bytes
+	}
+}
+
+// WithCompression sets the encoding of new megaconfigmaps, "" or combiner.EncodingGzip.
+// Compressed megaconfigmaps cannot be combined by combiners older than this package.
+func WithCompression(encoding string) Option {
+	return func(c *Client) {
+		c.encoding = encoding
+	}
+}
+
+// WithParallelism sets the number of partial configmaps written concurrently. The default is DefaultParallelism.
+func WithParallelism(n int) Option {
+	return func(c *Client) {
+		c.parallelism = n
+	}
+}
+
+// WithEventRecorder makes the Client record Events on megaconfigmaps it creates, updates and deletes.
+// Failures to record Events are ignored.
+func WithEventRecorder(recorder *events.Recorder) Option {
+	return func(c *Client) {
+		c.recorder = recorder
+	}
+}
+
+// WithProgress makes the Client call report each time a partial configmap is uploaded, or found intact when resuming.
+// report is called by one goroutine at a time.
+func WithProgress(report func(Progress)) Option {
+	return func(c *Client) {
+		c.progress = report
+	}
+}
+
+// New creates a Client
+func New(k8s kubernetes.Interface, opts ...Option) *Client {
+	c := &Client{
+		k8s:           k8s,
+		chunkSize:     DefaultChunkSize,
+		parallelism:   DefaultParallelism,
+		retries:       DefaultRetries,
+		retryInterval: DefaultRetryInterval,
+		after:         time.After,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WrapTransport sets FieldManager to write requests so that the validating webhook allows them.
+// Set it to rest.Config.WrapTransport; client-go does not take CreateOptions or UpdateOptions yet.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			req = req.Clone(req.Context())
+			q := req.URL.Query()
+			q.Set("fieldManager", FieldManager)
+			req.URL.RawQuery = q.Encode()
+		}
+		return rt.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Get returns the megaconfigmap namespace/name
+func (c *Client) Get(ctx context.Context, namespace, name string) (*MegaConfigMap, error) {
+	master, err := c.k8s.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromMaster(master)
+}
+
+// List returns the megaconfigmaps in namespace
+func (c *Client) List(ctx context.Context, namespace string) ([]*MegaConfigMap, error) {
+	masters, err := c.k8s.CoreV1().ConfigMaps(namespace).List(metav1.ListOptions{
+		LabelSelector: combiner.MasterLabel + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	mcms := make([]*MegaConfigMap, 0, len(masters.Items))
+	for i := range masters.Items {
+		mcm, err := fromMaster(&masters.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		mcms = append(mcms, mcm)
+	}
+	return mcms, nil
+}
+
+// Delete deletes the megaconfigmap namespace/name. Its partial configmaps are deleted by the garbage collector.
+func (c *Client) Delete(ctx context.Context, namespace, name string) error {
+	mcm, err := c.Get(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	master, err := c.markForDeletion(mcm.ConfigMap)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = c.k8s.CoreV1().ConfigMaps(namespace).Delete(master.Name, &metav1.DeleteOptions{
+		Preconditions:     &metav1.Preconditions{UID: &master.UID},
+		PropagationPolicy: &propagation,
+	})
+	if err != nil {
+		return err
+	}
+	c.recordEvent(master, corev1.EventTypeNormal, "Deleted", "Deleted megaconfigmap %s with id %s", master.Name, mcm.ID)
+	return nil
+}
+
+func fromMaster(master *corev1.ConfigMap) (*MegaConfigMap, error) {
+	if master.Labels[combiner.MasterLabel] != "true" {
+		return nil, fmt.Errorf("configmap %s is not a megaconfigmap", master.Name)
+	}
+	manifest, err := combiner.ManifestOf(master)
+	if err != nil {
+		return nil, err
+	}
+	return &MegaConfigMap{
+		Namespace: master.Namespace,
+		Name:      master.Name,
+		ID:        master.Labels[combiner.IDLabel],
+		FileName:  master.Labels[combiner.FileNameLabel],
+		Manifest:  manifest,
+		Pending:   len(master.Annotations[combiner.PendingAnnotation]) > 0,
+		ConfigMap: master,
+	}, nil
+}
+
+// markForDeletion annotates cm so that the validating webhook allows this package to delete it
+func (c *Client) markForDeletion(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	if len(cm.Annotations[combiner.DeletionRequestedAnnotation]) > 0 {
+		return cm, nil
+	}
+	cm = cm.DeepCopy()
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Annotations[combiner.DeletionRequestedAnnotation] = FieldManager
+	return c.k8s.CoreV1().ConfigMaps(cm.Namespace).Update(cm)
+}
+
+// deletePartials deletes the partial configmaps of the megaconfigmap id
+func (c *Client) deletePartials(namespace, id string) error {
+	return c.deleteSelected(namespace, fmt.Sprintf("%s=%s,%s!=true", combiner.IDLabel, id, combiner.MasterLabel))
+}
+
+// deleteStalePartials deletes the partial configmaps labeled with the name of master but not with id.
+// Partial configmaps of megaconfigmaps whose names are not valid label values are not labeled, and are left to the controller.
+func (c *Client) deleteStalePartials(master *corev1.ConfigMap, id string) error {
+	if len(validation.IsValidLabelValue(master.Name)) > 0 {
+		return nil
+	}
+	return c.deleteSelected(master.Namespace, fmt.Sprintf("%s=%s,%s,%s!=%s,%s!=true",
+		combiner.NameLabel, master.Name, combiner.IDLabel, combiner.IDLabel, id, combiner.MasterLabel))
+}
+
+// deleteSelected deletes the configmaps in namespace matching selector
+func (c *Client) deleteSelected(namespace, selector string) error {
+	configMaps := c.k8s.CoreV1().ConfigMaps(namespace)
+	cms, err := configMaps.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for i := range cms.Items {
+		cm, err := c.markForDeletion(&cms.Items[i])
+		if err != nil {
+			return err
+		}
+		err = configMaps.Delete(cm.Name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &cm.UID}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) recordEvent(master *corev1.ConfigMap, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Eventf(events.ConfigMapReference(master.Namespace, master.Name, master.UID), eventType, reason, messageFmt, args...)
+}
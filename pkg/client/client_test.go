@@ -0,0 +1,398 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClient_roundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+	}{
+		{name: "text", data: []byte("abcdefghij")},
+		{name: "split multi-byte characters", data: []byte("あいうえお")},
+		{name: "binary", data: []byte{0xff, 0xfe, 0x00, 0x01, 0x80}},
+		{name: "gzip", data: []byte("abcdefghijabcdefghij"), encoding: combiner.EncodingGzip},
+		{name: "empty", data: []byte{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			c := New(fake.NewSimpleClientset(), WithChunkSize(4), WithCompression(tt.encoding), WithParallelism(2))
+			mcm, err := c.Create(ctx, "default", "my-conf", "data", tt.data)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if mcm.ID != combiner.MapID(tt.data, "default", "my-conf") || mcm.FileName != "data" {
+				t.Errorf("unexpected megaconfigmap: %+v", mcm)
+			}
+			if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+			r, err := c.Open(ctx, "default", "my-conf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.data) {
+				t.Errorf("read %q, want %q", got, tt.data)
+			}
+
+			dir, err := ioutil.TempDir("", "megaconfigmap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			cb, err := combiner.NewCombiner("my-conf", dir, combiner.WithClient(c.k8s), combiner.WithNamespace("default"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cb.Run(); err != nil {
+				t.Fatalf("combiner failed: %v", err)
+			}
+			combined, err := ioutil.ReadFile(filepath.Join(dir, "data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(combined) != string(tt.data) {
+				t.Errorf("combined %q, want %q", combined, tt.data)
+			}
+		})
+	}
+}
+
+func TestClient_Open_seek(t *testing.T) {
+	ctx := context.Background()
+	c := New(fake.NewSimpleClientset(), WithChunkSize(3))
+	if _, err := c.Create(ctx, "default", "my-conf", "data", []byte("abcdefghij")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Open(ctx, "default", "my-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "efgh" {
+		t.Errorf("read %q after seek, want efgh", buf)
+	}
+	if _, err := r.Seek(-1, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil || string(rest) != "j" {
+		t.Errorf("read %q, %v at the end", rest, err)
+	}
+}
+
+func TestClient_Apply(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset()
+	c := New(k8s, WithChunkSize(3))
+	_, changed, err := c.Apply(ctx, "default", "my-conf", "data", []byte("abcdefg"))
+	if err != nil || !changed {
+		t.Fatalf("Apply() = %v, %v; want created", changed, err)
+	}
+	_, changed, err = c.Apply(ctx, "default", "my-conf", "data", []byte("abcdefg"))
+	if err != nil || changed {
+		t.Fatalf("Apply() = %v, %v; want unchanged", changed, err)
+	}
+	mcm, changed, err := c.Apply(ctx, "default", "my-conf", "data", []byte("xyz"))
+	if err != nil || !changed {
+		t.Fatalf("Apply() = %v, %v; want updated", changed, err)
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	partials, err := k8s.CoreV1().ConfigMaps("default").List(metav1.ListOptions{LabelSelector: combiner.IDLabel + "," + combiner.MasterLabel + "!=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partials.Items) != 1 || partials.Items[0].Labels[combiner.IDLabel] != mcm.ID {
+		t.Errorf("old partial configmaps remain: %d", len(partials.Items))
+	}
+
+	mcms, err := c.List(ctx, "default")
+	if err != nil || len(mcms) != 1 || mcms[0].Name != "my-conf" {
+		t.Errorf("List() = %v, %v", mcms, err)
+	}
+	if err := c.Delete(ctx, "default", "my-conf"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := c.Get(ctx, "default", "my-conf"); err == nil {
+		t.Error("megaconfigmap is not deleted")
+	}
+}
+
+func TestClient_Apply_resume(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset()
+	c := New(k8s, WithChunkSize(3), WithParallelism(1))
+	if _, err := c.Create(ctx, "default", "my-conf", "data", []byte("abcdefghi")); err != nil {
+		t.Fatal(err)
+	}
+	failed := false
+	k8s.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if cm := action.(k8stesting.UpdateAction).GetObject().(*corev1.ConfigMap); cm.Name == "my-conf-1" && !failed {
+			failed = true
+			return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), cm.Name, errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+	data := []byte("xyz123")
+	if _, _, err := c.Apply(ctx, "default", "my-conf", "data", data); err == nil {
+		t.Fatal("Apply() should fail")
+	}
+	mcm, err := c.Get(ctx, "default", "my-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mcm.Pending || mcm.ID != combiner.MapID(data, "default", "my-conf") {
+		t.Fatalf("megaconfigmap is not pending with the new version: %+v", mcm)
+	}
+
+	mcm, changed, err := c.Apply(ctx, "default", "my-conf", "data", data)
+	if err != nil || !changed {
+		t.Fatalf("Apply() = %v, %v; want resumed", changed, err)
+	}
+	if mcm.Pending {
+		t.Error("megaconfigmap is still pending")
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	// my-conf-2 of the old version is deleted
+	partials, err := k8s.CoreV1().ConfigMaps("default").List(metav1.ListOptions{LabelSelector: combiner.IDLabel + "," + combiner.MasterLabel + "!=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partials.Items) != 2 {
+		t.Errorf("got %d partial configmaps, want 2", len(partials.Items))
+	}
+}
+
+func TestClient_Verify_corrupted(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset()
+	c := New(k8s, WithChunkSize(3))
+	if _, err := c.Create(ctx, "default", "my-conf", "data", []byte("abcdefg")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := k8s.CoreV1().ConfigMaps("default").Get("my-conf-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Data[combiner.PartialItemKey] = "DEF"
+	if _, err := k8s.CoreV1().ConfigMaps("default").Update(p); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Verify(ctx, "default", "my-conf")
+	if combiner.ReasonOf(err) != combiner.ReasonChecksumMismatch {
+		t.Errorf("Verify() error = %v, want %s", err, combiner.ReasonChecksumMismatch)
+	}
+}
+
+func TestClient_Render(t *testing.T) {
+	data := []byte("abcdefghij")
+	objects, err := New(nil, WithChunkSize(4)).Render("default", "my-conf", "data", data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if len(objects) != 4 {
+		t.Fatalf("rendered %d objects, want 4", len(objects))
+	}
+	k8s := fake.NewSimpleClientset()
+	for _, cm := range objects[1:] {
+		if len(cm.OwnerReferences) > 0 || cm.Labels[combiner.NameLabel] != "my-conf" {
+			t.Errorf("%s is not linked by the label: %+v", cm.Name, cm.ObjectMeta)
+		}
+	}
+	for _, cm := range objects {
+		if cm.Kind != "ConfigMap" || len(cm.UID) > 0 {
+			t.Errorf("unexpected object: %+v", cm)
+		}
+		if _, err := k8s.CoreV1().ConfigMaps("default").Create(cm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "megaconfigmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cb, err := combiner.NewCombiner("my-conf", dir, combiner.WithClient(k8s), combiner.WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cb.Run(); err != nil {
+		t.Fatalf("combiner failed: %v", err)
+	}
+	combined, err := ioutil.ReadFile(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(combined) != string(data) {
+		t.Errorf("combined %q, want %q", combined, data)
+	}
+
+	again, err := New(nil, WithChunkSize(4)).Render("default", "my-conf", "data", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(objects, again) {
+		t.Error("Render() is not deterministic")
+	}
+
+	_, err = New(nil).Render("default", strings.Repeat("a", 64), "data", data)
+	if err == nil {
+		t.Error("Render() should fail for names longer than a label value")
+	}
+}
+
+func TestClient_Create_resume(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset()
+	failed := false
+	k8s.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if cm := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap); cm.Name == "my-conf-2" && !failed {
+			failed = true
+			return true, nil, errors.New("connection reset")
+		}
+		return false, nil, nil
+	})
+	c := New(k8s, WithChunkSize(2), WithParallelism(1))
+	data := []byte("0123456789")
+	if _, err := c.Create(ctx, "default", "my-conf", "conf.txt", data); err == nil {
+		t.Fatal("Create() should fail")
+	}
+	mcm, err := c.Get(ctx, "default", "my-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mcm.Pending {
+		t.Fatal("megaconfigmap is not pending")
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err == nil {
+		t.Error("pending megaconfigmap is verified")
+	}
+	if _, err := New(k8s, WithChunkSize(2)).Create(ctx, "default", "my-conf", "conf.txt", []byte("other")); err == nil {
+		t.Error("Create() with other content should fail")
+	}
+
+	// corrupt the uploaded my-conf-0
+	partial, err := k8s.CoreV1().ConfigMaps("default").Get("my-conf-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial.Data[combiner.PartialItemKey] = "xx"
+	if err := k8s.Tracker().Update(corev1.SchemeGroupVersion.WithResource("configmaps"), partial, "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the partial configmaps created before the failure depend on the order of goroutines
+	want := map[string]string{"my-conf-0": "update", "my-conf": "update"}
+	for i := 1; i < 5; i++ {
+		name := fmt.Sprintf("my-conf-%d", i)
+		if _, err := k8s.CoreV1().ConfigMaps("default").Get(name, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+			want[name] = "create"
+		}
+	}
+
+	k8s.ClearActions()
+	mcm, err = c.Create(ctx, "default", "my-conf", "conf.txt", data)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if mcm.Pending {
+		t.Error("megaconfigmap is still pending")
+	}
+	written := map[string]string{}
+	for _, action := range k8s.Actions() {
+		switch action := action.(type) {
+		case k8stesting.CreateAction:
+			written[action.GetObject().(*corev1.ConfigMap).Name] = action.GetVerb()
+		case k8stesting.UpdateAction:
+			written[action.GetObject().(*corev1.ConfigMap).Name] = action.GetVerb()
+		}
+	}
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("written %v, want %v", written, want)
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Create(ctx, "default", "my-conf", "conf.txt", data); !apierrors.IsAlreadyExists(err) {
+		t.Errorf("Create() of existing megaconfigmap = %v", err)
+	}
+}
+
+func TestClient_Create_afterDelete(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset()
+	// the fake clientset neither sets UIDs nor collects garbage, so the partial configmaps outlive the deleted master
+	uids := 0
+	k8s.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if cm := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap); cm.Labels[combiner.MasterLabel] == "true" {
+			uids++
+			cm.UID = types.UID(fmt.Sprintf("uid-%d", uids))
+		}
+		return false, nil, nil
+	})
+	c := New(k8s, WithChunkSize(2))
+	data := []byte("0123456789")
+	if _, err := c.Create(ctx, "default", "my-conf", "conf.txt", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "default", "my-conf"); err != nil {
+		t.Fatal(err)
+	}
+	mcm, err := c.Create(ctx, "default", "my-conf", "conf.txt", data)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if mcm.ConfigMap.UID != "uid-2" {
+		t.Fatalf("UID of the recreated megaconfigmap = %s", mcm.ConfigMap.UID)
+	}
+	for i := int64(0); i < 5; i++ {
+		partial, err := k8s.CoreV1().ConfigMaps("default").Get(combiner.PartialName("my-conf", i), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(partial.OwnerReferences) != 1 || partial.OwnerReferences[0].UID != mcm.ConfigMap.UID {
+			t.Errorf("owner references of %s = %v, want the recreated megaconfigmap", partial.Name, partial.OwnerReferences)
+		}
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,376 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Create creates the megaconfigmap namespace/name holding data as fileName.
+// The megaconfigmap is marked as pending until all partial configmaps are created. If creating them fails, it is left
+// pending, and Create with the same content resumes the upload by creating only the missing or corrupt partial configmaps.
+func (c *Client) Create(ctx context.Context, namespace, name, fileName string, data []byte) (*MegaConfigMap, error) {
+	p, err := c.Plan(namespace, name, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateFromPlan(ctx, p, fileName)
+}
+
+// CreateFromPlan creates the megaconfigmap planned by p holding its content as fileName, like Create.
+// It writes the content encoded by Plan, e.g. after checking p by Preflight.
+func (c *Client) CreateFromPlan(ctx context.Context, p *Plan, fileName string) (*MegaConfigMap, error) {
+	if p.manifest == nil {
+		return nil, errors.New("plan is not returned by Plan")
+	}
+	start := time.Now()
+	namespace, name, id, manifest, manifestData, chunks := p.Namespace, p.Name, p.ID, p.manifest, p.manifestData, p.chunks
+	configMaps := c.k8s.CoreV1().ConfigMaps(namespace)
+	master, err := configMaps.Get(name, metav1.GetOptions{})
+	resume := err == nil
+	switch {
+	case apierrors.IsNotFound(err):
+		pending := newMaster(namespace, name, id, fileName, manifestData)
+		pending.Annotations = map[string]string{combiner.PendingAnnotation: id}
+		master, err = configMaps.Create(pending)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case len(master.Annotations[combiner.PendingAnnotation]) == 0:
+		return nil, apierrors.NewAlreadyExists(corev1.Resource("configmaps"), name)
+	case master.Labels[combiner.IDLabel] != id || master.Labels[combiner.FileNameLabel] != fileName || master.Data[combiner.ManifestKey] != manifestData:
+		return nil, fmt.Errorf("megaconfigmap %s is pending with other content or block size; delete it to start over", name)
+	}
+
+	uploaded, err := c.uploadPartials(ctx, master, id, fileName, manifest, chunks, resume)
+	if err != nil {
+		c.recordEvent(master, corev1.EventTypeWarning, "CreateFailed", "Failed to create megaconfigmap from %s: %v", fileName, err)
+		return nil, err
+	}
+	if resume {
+		// the pending upload may have been started by Apply replacing another version
+		if err := c.deleteStalePartials(master, id); err != nil {
+			return nil, err
+		}
+	}
+	master = master.DeepCopy()
+	delete(master.Annotations, combiner.PendingAnnotation)
+	master, err = configMaps.Update(master)
+	if err != nil {
+		return nil, err
+	}
+	c.recordEvent(master, corev1.EventTypeNormal, "Created", "Created megaconfigmap from %s: %d chunks (%d uploaded), %d bytes, id %s in %s",
+		fileName, len(manifest.Chunks), uploaded, p.Bytes, id, time.Since(start).Round(time.Millisecond))
+	return fromMaster(master)
+}
+
+// Render returns the megaconfigmap namespace/name holding data as fileName and its partial configmaps without creating them.
+// The partials have no owner references; they are linked to the megaconfigmap by combiner.NameLabel and
+// the controller adds the owner references once the megaconfigmap is created.
+func (c *Client) Render(namespace, name, fileName string, data []byte) ([]*corev1.ConfigMap, error) {
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return nil, fmt.Errorf("name %s cannot be rendered, since it is not a valid label value: %s", name, strings.Join(errs, ", "))
+	}
+	id, manifest, chunks, err := c.encode(namespace, name, data)
+	if err != nil {
+		return nil, err
+	}
+	manifestData, err := manifest.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	master := newMaster(namespace, name, id, fileName, manifestData)
+	objects := []*corev1.ConfigMap{master}
+	for i, chunk := range chunks {
+		objects = append(objects, newPartial(master, id, fileName, int64(i), chunk))
+	}
+	for _, cm := range objects {
+		cm.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+	}
+	return objects, nil
+}
+
+// Unstructured returns obj, such as a configmap rendered by Render, as an unstructured object.
+// Fields set only by the API server, such as creationTimestamp, are omitted so that the output is stable.
+func Unstructured(obj runtime.Object) (map[string]interface{}, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	if meta, ok := u["metadata"].(map[string]interface{}); ok {
+		delete(meta, "creationTimestamp")
+	}
+	return u, nil
+}
+
+// Apply creates the megaconfigmap namespace/name, or replaces its content if it already exists.
+// It returns false if the megaconfigmap already holds data as fileName.
+// The megaconfigmap is pending while the partial configmaps are replaced; pods starting meanwhile fail to combine
+// and are retried by kubelet. If the update fails, it is left pending, and Apply with the same content resumes it.
+func (c *Client) Apply(ctx context.Context, namespace, name, fileName string, data []byte) (*MegaConfigMap, bool, error) {
+	current, err := c.Get(ctx, namespace, name)
+	if apierrors.IsNotFound(err) || (err == nil && current.Pending) {
+		mcm, err := c.Create(ctx, namespace, name, fileName, data)
+		return mcm, err == nil, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	start := time.Now()
+	id, manifest, chunks, err := c.encode(namespace, name, data)
+	if err != nil {
+		return nil, false, err
+	}
+	if current.ID == id && current.FileName == fileName {
+		return current, false, nil
+	}
+	manifestData, err := manifest.Marshal()
+	if err != nil {
+		return nil, false, err
+	}
+	// The master is marked as pending with the new version before any partial configmap is replaced,
+	// so that combiners do not read a mix of versions and a failed update is resumed by Apply or Create.
+	master := current.ConfigMap.DeepCopy()
+	master.Labels[combiner.IDLabel] = id
+	master.Labels[combiner.FileNameLabel] = fileName
+	if master.Data == nil {
+		master.Data = map[string]string{}
+	}
+	master.Data[combiner.ManifestKey] = manifestData
+	if master.Annotations == nil {
+		master.Annotations = map[string]string{}
+	}
+	master.Annotations[combiner.PendingAnnotation] = id
+	configMaps := c.k8s.CoreV1().ConfigMaps(namespace)
+	master, err = configMaps.Update(master)
+	if err != nil {
+		c.recordEvent(current.ConfigMap, corev1.EventTypeWarning, "UpdateFailed", "Failed to update megaconfigmap labels: %v", err)
+		return nil, false, err
+	}
+	// partial configmaps of the old version are replaced by name, and the rest are deleted after the upload
+	_, err = c.uploadPartials(ctx, master, id, fileName, manifest, chunks, true)
+	if err != nil {
+		c.recordEvent(master, corev1.EventTypeWarning, "UpdateFailed", "Failed to update megaconfigmap from %s: %v", fileName, err)
+		return nil, false, err
+	}
+	if current.ID != id {
+		if err := c.deletePartials(namespace, current.ID); err != nil {
+			c.recordEvent(master, corev1.EventTypeWarning, "UpdateFailed", "Failed to delete old partial configmaps: %v", err)
+			return nil, false, err
+		}
+	}
+	master = master.DeepCopy()
+	delete(master.Annotations, combiner.PendingAnnotation)
+	updated, err := configMaps.Update(master)
+	if err != nil {
+		return nil, false, err
+	}
+	c.recordEvent(updated, corev1.EventTypeNormal, "Updated", "Updated megaconfigmap from %s: %d chunks, %d bytes, id %s -> %s in %s",
+		fileName, len(manifest.Chunks), len(data), current.ID, id, time.Since(start).Round(time.Millisecond))
+	mcm, err := fromMaster(updated)
+	return mcm, true, err
+}
+
+// encode returns the megaconfigmap ID, the manifest and the chunks to store in partial configmaps
+func (c *Client) encode(namespace, name string, data []byte) (string, *combiner.Manifest, [][]byte, error) {
+	if c.chunkSize < 0 {
+		return "", nil, nil, errors.New("chunk size must not be negative")
+	}
+	if c.parallelism <= 0 {
+		return "", nil, nil, errors.New("parallelism must be positive")
+	}
+	stored := data
+	switch c.encoding {
+	case "":
+	case combiner.EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return "", nil, nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return "", nil, nil, err
+		}
+		stored = buf.Bytes()
+	default:
+		return "", nil, nil, fmt.Errorf("unknown compression %q", c.encoding)
+	}
+	chunks := c.split(stored)
+	manifest := combiner.NewChunkedManifest(data, chunks, c.encoding, name)
+	manifestData, err := manifest.Marshal()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := checkManifestSize(manifestData, len(chunks)); err != nil {
+		return "", nil, nil, err
+	}
+	return combiner.MapID(data, namespace, name), manifest, chunks, nil
+}
+
+// uploadPartials creates partial configmaps of chunks owned by master, and returns the number of uploaded ones.
+// With resume, partial configmaps matching the manifest are kept, and corrupt ones are replaced.
+// Otherwise, an existing partial configmap matching the manifest, e.g. created by a request whose response was lost, is kept.
+// Transient errors are retried, and all partial configmaps are tried even if some fail; the failures are returned as an *UploadError.
+func (c *Client) uploadPartials(ctx context.Context, master *corev1.ConfigMap, id, fileName string, manifest *combiner.Manifest,
+	chunks [][]byte, resume bool) (int, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		uploaded int
+		errs     []*PartialError
+	)
+	sem := make(chan struct{}, c.parallelism)
+	progress := c.newProgressTracker(chunks)
+	for i, chunk := range chunks {
+		i, chunk := int64(i), chunk
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			partial := newPartial(master, id, fileName, i, chunk)
+			written, err := c.writePartial(ctx, partial, id, manifest.Chunks[i], resume)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, &PartialError{Name: partial.Name, Order: i, Err: err})
+				return
+			}
+			if written {
+				uploaded++
+			}
+			progress.done(chunk)
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return uploaded, newUploadError(errs, len(chunks))
+	}
+	return uploaded, ctx.Err()
+}
+
+// writePartial creates partial, or replaces it with resume if it is corrupt. It also replaces a partial of the same content
+// owned by another master, e.g. one left by a deleted megaconfigmap, which the garbage collector would delete.
+// It returns false if it is already intact.
+func (c *Client) writePartial(ctx context.Context, partial *corev1.ConfigMap, id string, chunk combiner.Chunk, resume bool) (bool, error) {
+	configMaps := c.k8s.CoreV1().ConfigMaps(partial.Namespace)
+	var written bool
+	err := c.retry(ctx, func() error {
+		if !resume {
+			_, err := configMaps.Create(partial)
+			if !apierrors.IsAlreadyExists(err) {
+				written = err == nil
+				return err
+			}
+		}
+		current, err := configMaps.Get(partial.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configMaps.Create(partial)
+			written = err == nil
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := combiner.VerifyChunk(current, id, chunk); err != nil {
+			if !resume {
+				return fmt.Errorf("partial configmap %s already exists with other content", partial.Name)
+			}
+		} else if ownedBySameMaster(current, partial) {
+			return nil
+		}
+		replacement := partial.DeepCopy()
+		replacement.ResourceVersion = current.ResourceVersion
+		_, err = configMaps.Update(replacement)
+		if apierrors.IsNotFound(err) {
+			// deleted since the Get, e.g. as a partial configmap of an old version
+			_, err = configMaps.Create(partial)
+		}
+		written = err == nil
+		return err
+	})
+	return written, err
+}
+
+// ownedBySameMaster returns true if current has the owner of partial, or partial has no owner yet
+func ownedBySameMaster(current, partial *corev1.ConfigMap) bool {
+	for _, want := range partial.OwnerReferences {
+		found := false
+		for _, ref := range current.OwnerReferences {
+			if ref.UID == want.UID {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// newMaster returns the megaconfigmap holding the manifest
+func newMaster(namespace, name, id, fileName, manifestData string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels: map[string]string{
+				combiner.IDLabel:       id,
+				combiner.FileNameLabel: fileName,
+				combiner.MasterLabel:   "true",
+			},
+		},
+		Data: map[string]string{combiner.ManifestKey: manifestData},
+	}
+}
+
+// newPartial returns the order-th partial configmap, owned by master if it has been created.
+// The data is stored in binaryData unless it is text; see isText.
+func newPartial(master *corev1.ConfigMap, id, fileName string, order int64, data []byte) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: master.Namespace,
+			Name:      combiner.PartialName(master.Name, order),
+			Labels: map[string]string{
+				combiner.IDLabel:       id,
+				combiner.OrderLabel:    fmt.Sprintf("%d", order),
+				combiner.FileNameLabel: fileName,
+			},
+		},
+	}
+	if len(validation.IsValidLabelValue(master.Name)) == 0 {
+		cm.Labels[combiner.NameLabel] = master.Name
+	}
+	if len(master.UID) > 0 {
+		cm.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Name:       master.Name,
+			UID:        master.UID,
+		}}
+	}
+	if isText(data) {
+		cm.Data = map[string]string{combiner.PartialItemKey: string(data)}
+	} else {
+		cm.BinaryData = map[string][]byte{combiner.PartialItemKey: data}
+	}
+	return cm
+}
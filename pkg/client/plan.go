@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Plan describes what creating a megaconfigmap writes
+type Plan struct {
+	Namespace string
+	Name      string
+	// ID is the megaconfigmap ID of the content
+	ID string
+	// Objects is the number of configmaps including the megaconfigmap
+	Objects int64
+	// Bytes is the size of the content
+	Bytes int64
+	// EncodedBytes is the size of the content after compression
+	EncodedBytes int64
+	// StoredBytes is the size of the data of all configmaps, including base64 of binaryData and the manifest
+	StoredBytes int64
+	// Encoding is the compression of the content, or empty
+	Encoding string
+
+	manifest     *combiner.Manifest
+	manifestData string
+	chunks       [][]byte
+}
+
+// Savings returns the bytes saved by compression
+func (p *Plan) Savings() int64 {
+	return p.Bytes - p.EncodedBytes
+}
+
+// Limits are checked by Preflight in addition to the resource quotas
+type Limits struct {
+	// MaxBytes is the maximum StoredBytes of a megaconfigmap. Zero means no limit.
+	MaxBytes int64
+	// NamespaceBudget is the maximum StoredBytes of all megaconfigmaps in the namespace. Zero means no limit.
+	NamespaceBudget int64
+}
+
+// PreflightError lists the limits a Plan exceeds
+type PreflightError struct {
+	Plan       *Plan
+	Violations []string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("megaconfigmap %s would exceed limits: %s", e.Plan.Name, strings.Join(e.Violations, "; "))
+}
+
+// Plan returns what creating the megaconfigmap namespace/name holding data writes, without writing anything.
+// The Plan holds the encoded content, so that CreateFromPlan does not encode it again.
+func (c *Client) Plan(namespace, name string, data []byte) (*Plan, error) {
+	id, manifest, chunks, err := c.encode(namespace, name, data)
+	if err != nil {
+		return nil, err
+	}
+	manifestData, err := manifest.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{
+		Namespace:   namespace,
+		Name:        name,
+		ID:          id,
+		Objects:     int64(len(manifest.Chunks)) + 1,
+		Bytes:       int64(len(data)),
+		StoredBytes: int64(len(manifestData)),
+		Encoding:    c.encoding,
+
+		manifest:     manifest,
+		manifestData: manifestData,
+		chunks:       chunks,
+	}
+	for _, chunk := range chunks {
+		p.EncodedBytes += int64(len(chunk))
+		p.StoredBytes += storedSize(chunk)
+	}
+	return p, nil
+}
+
+// storedSize returns the size of a chunk in a partial configmap, which is base64 encoded if it is stored in binaryData
+func storedSize(chunk []byte) int64 {
+	if isText(chunk) {
+		return int64(len(chunk))
+	}
+	return int64(base64.StdEncoding.EncodedLen(len(chunk)))
+}
+
+// Preflight checks that p fits in limits and the resource quotas limiting configmaps in its namespace.
+// Configmaps of p uploaded by a pending Create are not counted again.
+// It returns a *PreflightError listing all exceeded limits. Resource quotas are not checked if they cannot be listed.
+func (c *Client) Preflight(ctx context.Context, p *Plan, limits Limits) error {
+	var violations []string
+	if limits.MaxBytes > 0 && p.StoredBytes > limits.MaxBytes {
+		violations = append(violations, fmt.Sprintf("%d bytes are stored, but the maximum is %d", p.StoredBytes, limits.MaxBytes))
+	}
+	if limits.NamespaceBudget > 0 {
+		used, err := c.storedInNamespace(ctx, p)
+		if err != nil {
+			return err
+		}
+		if used+p.StoredBytes > limits.NamespaceBudget {
+			violations = append(violations, fmt.Sprintf("megaconfigmaps in %s would store %d bytes, but the budget is %d", p.Namespace, used+p.StoredBytes, limits.NamespaceBudget))
+		}
+	}
+	quotas, err := c.k8s.CoreV1().ResourceQuotas(p.Namespace).List(metav1.ListOptions{})
+	if err != nil && !apierrors.IsForbidden(err) {
+		return err
+	}
+	if err == nil && len(quotas.Items) > 0 {
+		objects := p.Objects
+		uploaded, err := c.k8s.CoreV1().ConfigMaps(p.Namespace).List(metav1.ListOptions{LabelSelector: combiner.IDLabel + "=" + p.ID})
+		if err != nil {
+			return err
+		}
+		objects -= int64(len(uploaded.Items))
+		for _, quota := range quotas.Items {
+			for _, resource := range []corev1.ResourceName{"count/configmaps", corev1.ResourceConfigMaps} {
+				hard, ok := quota.Status.Hard[resource]
+				if !ok {
+					continue
+				}
+				used := quota.Status.Used[resource]
+				if headroom := hard.Value() - used.Value(); objects > headroom {
+					violations = append(violations, fmt.Sprintf("%d configmaps are created, but resource quota %s allows %d more", objects, quota.Name, headroom))
+				}
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &PreflightError{Plan: p, Violations: violations}
+	}
+	return nil
+}
+
+// storedInNamespace returns the bytes stored by the megaconfigmaps in the namespace of p except p itself.
+// Chunks of compressed megaconfigmaps are assumed to be binary. Megaconfigmaps without manifests are not counted.
+func (c *Client) storedInNamespace(ctx context.Context, p *Plan) (int64, error) {
+	mcms, err := c.List(ctx, p.Namespace)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, mcm := range mcms {
+		if mcm.Name == p.Name || mcm.Manifest == nil {
+			continue
+		}
+		total += int64(len(mcm.ConfigMap.Data[combiner.ManifestKey]))
+		for _, chunk := range mcm.Manifest.Chunks {
+			if len(mcm.Manifest.Encoding) > 0 {
+				total += int64(base64.StdEncoding.EncodedLen(int(chunk.Bytes)))
+			} else {
+				total += chunk.Bytes
+			}
+		}
+	}
+	return total, nil
+}
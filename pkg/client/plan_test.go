@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClient_Plan(t *testing.T) {
+	c := New(fake.NewSimpleClientset(), WithChunkSize(4))
+	p, err := c.Plan("default", "my-conf", []byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb, 'a'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Objects != 3 || p.Bytes != 6 || p.EncodedBytes != 6 || p.Savings() != 0 {
+		t.Errorf("unexpected plan: %+v", p)
+	}
+	// the first chunk is binary and base64 encoded, and the second is not
+	_, manifest, _, err := c.encode("default", "my-conf", []byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb, 'a'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestData, err := manifest.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(manifestData) + 8 + 4); p.StoredBytes != want {
+		t.Errorf("StoredBytes = %d, want %d", p.StoredBytes, want)
+	}
+
+	c = New(fake.NewSimpleClientset(), WithChunkSize(1024), WithCompression(combiner.EncodingGzip))
+	p, err = c.Plan("default", "my-conf", bytes.Repeat([]byte("a"), 10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Objects != 2 || p.Savings() <= 0 || p.Encoding != combiner.EncodingGzip {
+		t.Errorf("unexpected plan: %+v", p)
+	}
+}
+
+func TestClient_Preflight(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset(&corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "configmaps"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{"count/configmaps": resource.MustParse("10")},
+			Used: corev1.ResourceList{"count/configmaps": resource.MustParse("7")},
+		},
+	})
+	c := New(k8s, WithChunkSize(4))
+	if _, err := c.Create(ctx, "default", "existing", "conf.txt", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	k8s.ClearActions()
+	p, err := c.Plan("default", "my-conf", []byte("01234567"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Preflight(ctx, p, Limits{MaxBytes: 1024, NamespaceBudget: 1024}); err != nil {
+		t.Errorf("Preflight() = %v", err)
+	}
+
+	p, err = c.Plan("default", "my-conf", []byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Preflight(ctx, p, Limits{MaxBytes: 10, NamespaceBudget: p.StoredBytes + 1})
+	preflightErr, ok := err.(*PreflightError)
+	if !ok {
+		t.Fatalf("Preflight() = %v, want *PreflightError", err)
+	}
+	if len(preflightErr.Violations) != 3 {
+		t.Errorf("unexpected violations: %v", preflightErr.Violations)
+	}
+	for _, want := range []string{"maximum is 10", "budget is", "resource quota configmaps allows 3 more"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q does not contain %q", err.Error(), want)
+		}
+	}
+	for _, action := range k8s.Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			t.Errorf("Preflight() wrote: %v", action)
+		}
+	}
+}
+
+func TestClient_CreateFromPlan(t *testing.T) {
+	ctx := context.Background()
+	c := New(fake.NewSimpleClientset(), WithChunkSize(4), WithCompression(combiner.EncodingGzip))
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	p, err := c.Plan("default", "my-conf", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcm, err := c.CreateFromPlan(ctx, p, "conf.txt")
+	if err != nil {
+		t.Fatalf("CreateFromPlan() error = %v", err)
+	}
+	if mcm.ID != p.ID || int64(len(mcm.Manifest.Chunks))+1 != p.Objects {
+		t.Errorf("megaconfigmap %+v does not match plan %+v", mcm, p)
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.CreateFromPlan(ctx, &Plan{Namespace: "default", Name: "other"}, "conf.txt"); err == nil {
+		t.Error("CreateFromPlan() should fail with a plan not returned by Plan")
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import "sync"
+
+// Progress is the progress of uploading partial configmaps
+type Progress struct {
+	// Chunks is the number of partial configmaps done
+	Chunks int
+	// TotalChunks is the number of partial configmaps to upload
+	TotalChunks int
+	// Bytes is the size of the chunks done
+	Bytes int64
+	// TotalBytes is the size of all chunks
+	TotalBytes int64
+}
+
+// progressTracker counts chunks done by concurrent uploads and reports them
+type progressTracker struct {
+	mu       sync.Mutex
+	report   func(Progress)
+	progress Progress
+}
+
+func (c *Client) newProgressTracker(chunks [][]byte) *progressTracker {
+	t := &progressTracker{report: c.progress, progress: Progress{TotalChunks: len(chunks)}}
+	for _, chunk := range chunks {
+		t.progress.TotalBytes += int64(len(chunk))
+	}
+	return t
+}
+
+// done records that chunk is uploaded or intact
+func (t *progressTracker) done(chunk []byte) {
+	if t.report == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Chunks++
+	t.progress.Bytes += int64(len(chunk))
+	t.report(t.progress)
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClient_WithProgress(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefghij"), int(MinChunkSize)/10*3)
+	var reports []Progress
+	c := New(fake.NewSimpleClientset(), WithChunkSize(MinChunkSize), WithProgress(func(p Progress) {
+		reports = append(reports, p)
+	}))
+	mcm, err := c.Create(context.Background(), "default", "my-conf", "conf.txt", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := len(mcm.Manifest.Chunks)
+	if len(reports) != chunks {
+		t.Fatalf("got %d reports, want %d", len(reports), chunks)
+	}
+	for i, p := range reports {
+		if p.Chunks != i+1 || p.TotalChunks != chunks || p.TotalBytes != int64(len(data)) {
+			t.Errorf("report %d = %+v", i, p)
+		}
+	}
+	if last := reports[len(reports)-1]; last.Bytes != last.TotalBytes {
+		t.Errorf("last report = %+v, want all bytes", last)
+	}
+}
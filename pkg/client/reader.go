@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+)
+
+// Open returns a reader of the content of the megaconfigmap namespace/name.
+// Partial configmaps are fetched as they are read, and each chunk is verified against the manifest.
+// Compressed megaconfigmaps are read into memory at once.
+func (c *Client) Open(ctx context.Context, namespace, name string) (io.ReadSeeker, error) {
+	mcm, err := c.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if mcm.Manifest != nil && len(mcm.Manifest.Encoding) > 0 {
+		rc, err := combiner.Open(ctx, c.k8s, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+	r, err := combiner.NewChunkReader(ctx, c.k8s, namespace, name, combiner.DefaultCacheChunks)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(r, 0, r.Size()), nil
+}
+
+// Verify reads the whole content of the megaconfigmap namespace/name and checks it against its ID and manifest
+func (c *Client) Verify(ctx context.Context, namespace, name string) error {
+	mcm, err := c.Get(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	r, err := c.Open(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	id, digest := sha1.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(id, digest), r)
+	if err != nil {
+		return err
+	}
+	id.Write([]byte(namespace))
+	id.Write([]byte(name))
+	if actual := fmt.Sprintf("%x", id.Sum(nil)); actual != mcm.ID {
+		return &combiner.Error{Reason: combiner.ReasonChecksumMismatch, Err: fmt.Errorf("id is %s, want %s", actual, mcm.ID)}
+	}
+	if mcm.Manifest == nil {
+		return nil
+	}
+	if size != mcm.Manifest.Bytes {
+		return &combiner.Error{Reason: combiner.ReasonChecksumMismatch, Err: fmt.Errorf("size is %d, want %d", size, mcm.Manifest.Bytes)}
+	}
+	if actual := fmt.Sprintf("%x", digest.Sum(nil)); actual != mcm.Manifest.SHA256 {
+		return &combiner.Error{Reason: combiner.ReasonChecksumMismatch, Err: fmt.Errorf("sha256 is %s, want %s", actual, mcm.Manifest.SHA256)}
+	}
+	return nil
+}
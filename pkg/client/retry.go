@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+const (
+	// DefaultRetries is the default number of retries of a failed write to a partial configmap
+	DefaultRetries = 5
+	// DefaultRetryInterval is the default interval before the first retry, doubled after each retry
+	DefaultRetryInterval = 500 * time.Millisecond
+	// maxRetryInterval caps the backoff, but not the Retry-After of the API server
+	maxRetryInterval = 30 * time.Second
+)
+
+// PartialError is the failure to write a partial configmap
+type PartialError struct {
+	// Name is the name of the partial configmap
+	Name string
+	// Order is the position of the chunk in the manifest
+	Order int64
+	// Err is the last error after retries
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the cause
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// UploadError lists all partial configmaps that failed to be written
+type UploadError struct {
+	// Errors are sorted by Order
+	Errors []*PartialError
+	// Total is the number of partial configmaps to write
+	Total int
+}
+
+func (e *UploadError) Error() string {
+	causes := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		causes[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to write %d of %d partial configmaps: %s", len(e.Errors), e.Total, strings.Join(causes, "; "))
+}
+
+func newUploadError(errs []*PartialError, total int) *UploadError {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Order < errs[j].Order })
+	return &UploadError{Errors: errs, Total: total}
+}
+
+// WithRetry sets the number of retries of a failed write to a partial configmap and the interval before the first retry.
+// The interval is doubled after each retry, and the Retry-After of the API server is honored.
+// Only conflicts, throttling, server errors and dropped connections are retried.
+func WithRetry(retries int, interval time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryInterval = interval
+	}
+}
+
+// retry calls f until it succeeds, fails with an error not worth retrying, or runs out of retries
+func (c *Client) retry(ctx context.Context, f func() error) error {
+	interval := c.retryInterval
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.retries || !retriable(err) {
+			return err
+		}
+		delay := interval
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-c.after(delay):
+		}
+		interval *= 2
+		if interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
+
+// retriable returns true if err is likely transient
+func retriable(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsTooManyRequests(err) || apierrors.IsInternalError(err) ||
+		apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err)
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newRetryTestClient returns a Client recording the backoff delays instead of sleeping
+func newRetryTestClient(k8s *fake.Clientset, delays *[]time.Duration) *Client {
+	c := New(k8s, WithChunkSize(2), WithParallelism(1), WithRetry(3, time.Millisecond))
+	c.after = func(d time.Duration) <-chan time.Time {
+		*delays = append(*delays, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	return c
+}
+
+func TestClient_Create_retry(t *testing.T) {
+	k8s := fake.NewSimpleClientset()
+	errs := []error{
+		apierrors.NewTooManyRequests("slow down", 3),
+		apierrors.NewTooManyRequests("slow down", 3),
+		apierrors.NewInternalError(errors.New("etcd is busy")),
+	}
+	k8s.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if cm := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap); cm.Name == "my-conf-1" && len(errs) > 0 {
+			err := errs[0]
+			errs = errs[1:]
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	var delays []time.Duration
+	c := newRetryTestClient(k8s, &delays)
+	ctx := context.Background()
+	if _, err := c.Create(ctx, "default", "my-conf", "conf.txt", []byte("0123456789")); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	// Retry-After is honored, and the backoff doubles after each retry
+	want := []time.Duration{3 * time.Second, 3 * time.Second, 4 * time.Millisecond}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_Create_lostResponse(t *testing.T) {
+	k8s := fake.NewSimpleClientset()
+	lost := false
+	k8s.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cm := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap)
+		if cm.Name != "my-conf-2" || lost {
+			return false, nil, nil
+		}
+		// the partial configmap is created, but the response does not arrive
+		lost = true
+		if err := k8s.Tracker().Create(corev1.SchemeGroupVersion.WithResource("configmaps"), cm, cm.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, nil, apierrors.NewTimeoutError("request timed out", 0)
+	})
+	var delays []time.Duration
+	c := newRetryTestClient(k8s, &delays)
+	ctx := context.Background()
+	if _, err := c.Create(ctx, "default", "my-conf", "conf.txt", []byte("0123456789")); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if len(delays) != 1 {
+		t.Errorf("retried %d times, want 1", len(delays))
+	}
+	if err := c.Verify(ctx, "default", "my-conf"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_Create_uploadError(t *testing.T) {
+	k8s := fake.NewSimpleClientset()
+	attempts := map[string]int{}
+	k8s.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cm := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap)
+		attempts[cm.Name]++
+		switch cm.Name {
+		case "my-conf-1":
+			return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), cm.Name, errors.New("denied by webhook"))
+		case "my-conf-3":
+			return true, nil, apierrors.NewInternalError(errors.New("etcd is down"))
+		}
+		return false, nil, nil
+	})
+	var delays []time.Duration
+	c := newRetryTestClient(k8s, &delays)
+	_, err := c.Create(context.Background(), "default", "my-conf", "conf.txt", []byte("0123456789"))
+	var uploadErr *UploadError
+	if !errors.As(err, &uploadErr) {
+		t.Fatalf("Create() error = %v, want *UploadError", err)
+	}
+	if uploadErr.Total != 5 || len(uploadErr.Errors) != 2 {
+		t.Fatalf("UploadError = %v", uploadErr)
+	}
+	if e := uploadErr.Errors[0]; e.Name != "my-conf-1" || !apierrors.IsForbidden(e.Err) {
+		t.Errorf("Errors[0] = %v", e)
+	}
+	if e := uploadErr.Errors[1]; e.Name != "my-conf-3" || !apierrors.IsInternalError(e.Err) {
+		t.Errorf("Errors[1] = %v", e)
+	}
+	// forbidden is not retried, and the other chunks are written despite the failures
+	want := map[string]int{"my-conf": 1, "my-conf-0": 1, "my-conf-1": 1, "my-conf-2": 1, "my-conf-3": 4, "my-conf-4": 1}
+	if !reflect.DeepEqual(attempts, want) {
+		t.Errorf("attempts = %v, want %v", attempts, want)
+	}
+}
@@ -0,0 +1,124 @@
+package combiner
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+const cacheDialTimeout = 5 * time.Second
+
+// CachePath returns the URL path of a megaconfigmap version served by the node-local cache
+func CachePath(namespace, name, id string) string {
+	return fmt.Sprintf("/v1/namespaces/%s/megaconfigmaps/%s/%s", namespace, name, id)
+}
+
+// newCacheClient returns an HTTP client and the base URL for endpoint, which is unix:///PATH or https://HOST:PORT.
+// Plain HTTP is rejected, since the bearer token would be sent in plaintext.
+func newCacheClient(endpoint string, tlsConfig *tls.Config) (*http.Client, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	dialer := &net.Dialer{Timeout: cacheDialTimeout}
+	switch u.Scheme {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", u.Path)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://cache", nil
+	case "https":
+		transport := &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsConfig}
+		return &http.Client{Transport: transport}, endpoint, nil
+	}
+	return nil, "", fmt.Errorf("invalid cache endpoint %s; must be unix:///PATH or https://HOST:PORT", endpoint)
+}
+
+// cacheTLSConfig returns the TLS config to verify the node-local cache with the CA in cacheCAFile
+func (c *Combiner) cacheTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.cacheServerName}
+	if len(c.cacheCAFile) == 0 {
+		return config, nil
+	}
+	ca, err := ioutil.ReadFile(c.cacheCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate is found in %s", c.cacheCAFile)
+	}
+	return config, nil
+}
+
+// bearerToken returns the bearer token of config, e.g. the service account token of the pod
+func bearerToken(config *rest.Config) string {
+	if len(config.BearerToken) > 0 || len(config.BearerTokenFile) == 0 {
+		return config.BearerToken
+	}
+	token, err := ioutil.ReadFile(config.BearerTokenFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(token))
+}
+
+// fetchFromCache downloads the megaconfigmap version id from the node-local cache into a temporary file in the share directory.
+// The file is removed unless it matches id.
+func (c *Combiner) fetchFromCache(id string) (string, error) {
+	tlsConfig, err := c.cacheTLSConfig()
+	if err != nil {
+		return "", err
+	}
+	client, base, err := newCacheClient(c.cacheEndpoint, tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, base+CachePath(c.namespace, c.megaConfigMapName, id), nil)
+	if err != nil {
+		return "", err
+	}
+	if len(c.cacheToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.cacheToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("cache responded %s: %s", resp.Status, msg)
+	}
+
+	tmp, err := ioutil.TempFile(c.shareDir, "megaconfigmap")
+	if err != nil {
+		return "", newError(ReasonIO, err)
+	}
+	defer tmp.Close()
+	h := newIDHash(c.namespace, c.megaConfigMapName)
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if err == nil {
+		if actual := h.ID(); actual != id {
+			err = fmt.Errorf("cache responded %s, want %s", actual, id)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,118 @@
+package combiner_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCombiner_Run_cache(t *testing.T) {
+	data := "abcdefg"
+	cms := megaconfigmaptest.LegacyConfigMaps("default", "my-conf", []byte(data), len(data))
+	id, master, partial := cms[0].Labels[combiner.IDLabel], cms[0], cms[1]
+	serve := func(body string) *httptest.Server {
+		return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer my-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path != combiner.CachePath("default", "my-conf", id) {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+	}
+	good := serve(data)
+	defer good.Close()
+	corrupted := serve("ABCDEFG")
+	defer corrupted.Close()
+	// the token must not be sent in plaintext
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request with Authorization %q is sent over plain HTTP", r.Header.Get("Authorization"))
+	}))
+	defer plain.Close()
+
+	caDir, err := ioutil.TempDir("", "megaconfigmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(caDir)
+	caFile := filepath.Join(caDir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: good.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		endpoint      string
+		objects       []*corev1.ConfigMap
+		wantFromCache bool
+	}{
+		{
+			name:          "cache",
+			endpoint:      good.URL,
+			objects:       []*corev1.ConfigMap{master},
+			wantFromCache: true,
+		},
+		{
+			name:     "corrupted cache",
+			endpoint: corrupted.URL,
+			objects:  []*corev1.ConfigMap{master, partial},
+		},
+		{
+			name:     "plain HTTP",
+			endpoint: plain.URL,
+			objects:  []*corev1.ConfigMap{master, partial},
+		},
+		{
+			name:     "unavailable cache",
+			endpoint: "unix:///nonexistent/cache.sock",
+			objects:  []*corev1.ConfigMap{master, partial},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "megaconfigmap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			cluster := megaconfigmaptest.New(t)
+			cluster.Put(tt.objects...)
+			c, err := combiner.NewCombiner("my-conf", dir, combiner.WithClient(cluster.Client()),
+				combiner.WithNamespace("default"), combiner.WithCacheEndpoint(tt.endpoint), combiner.WithCacheToken("my-token"),
+				combiner.WithCacheTLS(caFile, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := c.Run()
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if result.FromCache != tt.wantFromCache {
+				t.Errorf("FromCache = %v, want %v; cache error = %v", result.FromCache, tt.wantFromCache, result.CacheError)
+			}
+			if !tt.wantFromCache && result.CacheError == nil {
+				t.Error("CacheError should be set")
+			}
+			got, err := ioutil.ReadFile(filepath.Join(dir, "data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != data {
+				t.Errorf("combined data = %s, want %s", got, data)
+			}
+		})
+	}
+}
@@ -1,18 +1,25 @@
 package combiner
 
 import (
+	"compress/gzip"
 	"crypto/sha1"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -25,79 +32,274 @@ const (
 	FileNameLabel = labelNamespace + "/filename"
 	// MasterLabel
 	MasterLabel = labelNamespace + "/master"
+	// NameLabel is the name of the megaconfigmap a partial configmap belongs to.
+	// It links partials rendered without owner references, since the UID of the megaconfigmap is not known until it is created.
+	NameLabel = labelNamespace + "/name"
 	// PartialItemKet is the configmap key to store partial data
 	PartialItemKey = "partial-item"
+	// DeletionRequestedAnnotation marks a megaconfigmap or partial configmap that kubectl-megaconfigmap is going to delete
+	DeletionRequestedAnnotation = labelNamespace + "/deletion-requested"
+	// PendingAnnotation marks a megaconfigmap whose partial configmaps are being uploaded. Its value is the ID being uploaded.
+	// kubectl-megaconfigmap removes it when all of them are uploaded, and resumes the upload while it remains.
+	PendingAnnotation = labelNamespace + "/pending"
+	// BreakGlassAnnotation allows anyone to modify or delete a megaconfigmap or partial configmap
+	BreakGlassAnnotation = labelNamespace + "/break-glass"
+
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 )
 
 // Combiner
 type Combiner struct {
 	megaConfigMapName string
+	namespace         string
 	shareDir          string
-	k8s               *kubernetes.Clientset
+	fileName          string
+	fileMode          os.FileMode
+	skipIfCurrent     bool
+	verifyCached      bool
+	cacheEndpoint     string
+	cacheToken        string
+	cacheCAFile       string
+	cacheServerName   string
+	k8s               kubernetes.Interface
+
+	mu        sync.Mutex
+	masterUID types.UID
+}
+
+// Result describes a successful combination
+type Result struct {
+	// ID is the megaconfigmap ID of the combined file
+	ID string
+	// Path is the path of the combined file
+	Path string
+	// Chunks is the number of partial configmaps combined
+	Chunks int
+	// Bytes is the size of the combined file
+	Bytes int64
+	// Skipped is true if the file in the share directory was already up to date
+	Skipped bool
+	// FromCache is true if the file was downloaded from the node-local cache
+	FromCache bool
+	// CacheError is why the node-local cache was not used, if it is configured
+	CacheError error
+}
+
+// Namespace returns the namespace of the megaconfigmap
+func (c *Combiner) Namespace() string {
+	return c.namespace
+}
+
+// Client returns the Kubernetes client used by the Combiner
+func (c *Combiner) Client() kubernetes.Interface {
+	return c.k8s
+}
+
+// MasterUID returns the UID of the megaconfigmap last read by the Combiner, or an empty UID if it has not been read
+func (c *Combiner) MasterUID() types.UID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.masterUID
 }
 
 // Run
-func (c *Combiner) Run() error {
-	namespaceBytes, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
-	namespace := string(namespaceBytes)
+func (c *Combiner) Run() (*Result, error) {
+	megaConfig, manifest, err := c.getMaster()
 	if err != nil {
-		return fmt.Errorf("failed to get current context namespace; %w", err)
+		return nil, err
+	}
+	labelMapID := megaConfig.Labels[IDLabel]
+	fileName, ok := megaConfig.Labels[FileNameLabel]
+	if !ok {
+		return nil, newError(ReasonInvalidConfigMap, errors.New(FileNameLabel+" is not found in megaconfigmap"))
+	}
+	if len(c.fileName) > 0 {
+		fileName = c.fileName
+	}
+	path := filepath.Join(c.shareDir, fileName)
+	if c.skipIfCurrent {
+		state, err := c.currentState(labelMapID, fileName)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			return &Result{ID: labelMapID, Path: path, Bytes: state.Bytes, Skipped: true}, nil
+		}
 	}
 
-	megaConfig, err := c.k8s.CoreV1().ConfigMaps(namespace).Get(c.megaConfigMapName, metav1.GetOptions{})
+	err = c.preflight(fileName, manifest)
 	if err != nil {
-		return fmt.Errorf("failed to get megaconfigmap %s; %w", c.megaConfigMapName, err)
+		return nil, err
 	}
-	labelMapID, ok := megaConfig.GetLabels()[IDLabel]
-	if !ok {
-		return errors.New(IDLabel + " is not found in megaconfigmap " + c.megaConfigMapName)
+
+	result := &Result{ID: labelMapID, Path: path}
+	var tempFileName string
+	if len(c.cacheEndpoint) > 0 {
+		tempFileName, result.CacheError = c.fetchFromCache(labelMapID)
+		result.FromCache = result.CacheError == nil
 	}
-	configmaps, err := c.k8s.CoreV1().ConfigMaps(namespace).List(
-		metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s!=true", IDLabel, labelMapID, MasterLabel)})
+	if !result.FromCache {
+		tempFileName, result.Chunks, err = c.assemble(labelMapID, manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer os.Remove(tempFileName)
+	size, err := c.verifyFile(tempFileName, labelMapID)
 	if err != nil {
-		return fmt.Errorf("failed to list configmaps; %w", err)
+		return nil, err
+	}
+	if c.fileMode != 0 {
+		if err := os.Chmod(tempFileName, c.fileMode); err != nil {
+			return nil, newError(ReasonIO, err)
+		}
+	}
+	if err := os.Rename(tempFileName, path); err != nil {
+		return nil, newError(ReasonIO, err)
+	}
+	if c.skipIfCurrent {
+		digest, err := fileDigest(path)
+		if err != nil {
+			return nil, newError(ReasonIO, err)
+		}
+		err = c.writeState(&State{
+			ID:        labelMapID,
+			FileName:  fileName,
+			SHA256:    digest,
+			Bytes:     size,
+			WrittenAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return nil, newError(ReasonIO, fmt.Errorf("failed to write state file; %w", err))
+		}
+	}
+	result.Bytes = size
+	return result, nil
+}
+
+// assemble writes the partial configmaps of the megaconfigmap id to a temporary file in the share directory, decoding them if needed.
+// The partials are got by the names in the manifest, so the combiner does not need to list configmaps.
+// It returns the name of the file and the number of the partial configmaps.
+func (c *Combiner) assemble(id string, manifest *Manifest) (string, int, error) {
+	if manifest == nil {
+		return c.assembleByLabel(id)
+	}
+	tmp, err := ioutil.TempFile(c.shareDir, "megaconfigmap")
+	if err != nil {
+		return "", 0, newError(ReasonIO, err)
+	}
+	for _, chunk := range manifest.Chunks {
+		data, err := getChunk(c.k8s, c.namespace, id, chunk)
+		if err == nil {
+			_, err = tmp.Write(data)
+			err = newError(ReasonIO, err)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return "", 0, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, newError(ReasonIO, err)
+	}
+	tempFileName := tmp.Name()
+	if len(manifest.Encoding) > 0 {
+		decoded, err := c.decode(tempFileName, manifest.Encoding)
+		os.Remove(tempFileName)
+		if err != nil {
+			return "", 0, err
+		}
+		tempFileName = decoded
+	}
+	return tempFileName, len(manifest.Chunks), nil
+}
+
+// assembleByLabel writes the partial configmaps of the megaconfigmap id to a temporary file in the share directory.
+// Megaconfigmaps created by older versions have no manifest, so their partials are listed by the label.
+func (c *Combiner) assembleByLabel(id string) (string, int, error) {
+	configmaps, err := c.k8s.CoreV1().ConfigMaps(c.namespace).List(
+		metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s!=true", IDLabel, id, MasterLabel)})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to list configmaps; %w", err)
 	}
 	tempFileName, err := c.WriteTemp(configmaps)
 	if err != nil {
-		return fmt.Errorf("failed to write to tempfile; %w", err)
+		return "", 0, fmt.Errorf("failed to write to tempfile; %w", err)
 	}
-	data, err := ioutil.ReadFile(tempFileName)
+	return tempFileName, len(configmaps.Items), nil
+}
+
+// verifyFile checks that the file matches the megaconfigmap id and returns its size
+func (c *Combiner) verifyFile(fileName, id string) (int64, error) {
+	f, err := os.Open(fileName)
 	if err != nil {
-		return err
+		return 0, newError(ReasonIO, err)
 	}
-	currentMapID := MapID(data, megaConfig.Namespace, megaConfig.Name)
-	if labelMapID != currentMapID {
-		return fmt.Errorf("checksum is not matched. checksumInLabel:%s, checksumActual:%s", labelMapID, currentMapID)
+	defer f.Close()
+	h := newIDHash(c.namespace, c.megaConfigMapName)
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, newError(ReasonIO, err)
 	}
-	fileName, ok := megaConfig.Labels[FileNameLabel]
-	if !ok {
-		return errors.New(FileNameLabel + " is not found in megaconfigmap")
+	if actual := h.ID(); actual != id {
+		return 0, newError(ReasonChecksumMismatch,
+			fmt.Errorf("checksum is not matched. checksumInLabel:%s, checksumActual:%s", id, actual))
 	}
-	return os.Rename(tempFileName, filepath.Join(c.shareDir, fileName))
+	return size, nil
 }
 
 // Write writes data from ConfigMap list
 func (c *Combiner) WriteTemp(configmaps *corev1.ConfigMapList) (string, error) {
-	tmp, err := ioutil.TempFile(c.shareDir, "megaconfigmap")
+	contents, err := c.sortContents(configmaps)
 	if err != nil {
-		return "", err
+		return "", newError(ReasonInvalidConfigMap, err)
 	}
-	defer tmp.Close()
 
-	contents, err := c.sortContents(configmaps)
+	tmp, err := ioutil.TempFile(c.shareDir, "megaconfigmap")
 	if err != nil {
-		return "", err
+		return "", newError(ReasonIO, err)
 	}
+	defer tmp.Close()
 
 	for _, partialContent := range contents {
 		_, err = tmp.WriteString(partialContent)
 		if err != nil {
-			return "", err
+			os.Remove(tmp.Name())
+			return "", newError(ReasonIO, err)
 		}
 	}
 	return tmp.Name(), nil
 }
 
+// decode writes the file encoded by encoding to another temporary file
+func (c *Combiner) decode(fileName, encoding string) (string, error) {
+	if encoding != EncodingGzip {
+		return "", newError(ReasonInvalidConfigMap, fmt.Errorf("unknown encoding %q", encoding))
+	}
+	in, err := os.Open(fileName)
+	if err != nil {
+		return "", newError(ReasonIO, err)
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return "", newError(ReasonChecksumMismatch, fmt.Errorf("failed to decompress; %w", err))
+	}
+	out, err := ioutil.TempFile(c.shareDir, "megaconfigmap")
+	if err != nil {
+		return "", newError(ReasonIO, err)
+	}
+	defer out.Close()
+	_, err = io.Copy(out, zr)
+	if err != nil {
+		os.Remove(out.Name())
+		return "", newError(ReasonChecksumMismatch, fmt.Errorf("failed to decompress; %w", err))
+	}
+	return out.Name(), nil
+}
+
 func (c *Combiner) sortContents(configmaps *corev1.ConfigMapList) ([]string, error) {
 	contents := make([]string, len(configmaps.Items))
 	for _, cm := range configmaps.Items {
@@ -109,41 +311,210 @@ func (c *Combiner) sortContents(configmaps *corev1.ConfigMapList) ([]string, err
 		if err != nil {
 			return nil, err
 		}
-		partial, ok := cm.Data[PartialItemKey]
+		partial, ok := PartialData(&cm)
 		if !ok {
 			return nil, fmt.Errorf("partial-item is not found in configmap %s/%s", cm.GetNamespace(), cm.GetName())
 		}
-		if len(contents) < ordering {
+		if ordering < 0 || len(contents) <= ordering {
 			return nil, fmt.Errorf("out of index from contents slice. ordering: %d", ordering)
 		}
-		contents[ordering] = partial
+		contents[ordering] = string(partial)
 	}
 	return contents, nil
 }
 
+// Option configures a Combiner
+type Option func(*options)
+
+type options struct {
+	client          kubernetes.Interface
+	fileName        string
+	fileMode        os.FileMode
+	kubeconfig      string
+	context         string
+	namespace       string
+	skipIfCurrent   bool
+	verifyCached    bool
+	cacheEndpoint   string
+	cacheToken      string
+	cacheCAFile     string
+	cacheServerName string
+}
+
+// WithClient makes the Combiner use k8s instead of loading a config.
+// The namespace must be given by WithNamespace.
+func WithClient(k8s kubernetes.Interface) Option {
+	return func(o *options) {
+		o.client = k8s
+	}
+}
+
+// WithKubeconfig makes the Combiner use the kubeconfig file and its context instead of the in-cluster config.
+// Empty values fall back to the default loading rules and the current context.
+func WithKubeconfig(kubeconfig, context string) Option {
+	return func(o *options) {
+		o.kubeconfig = kubeconfig
+		o.context = context
+	}
+}
+
+// WithNamespace sets the namespace of the megaconfigmap.
+// The default is the namespace of the pod, or of the kubeconfig context out of cluster.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithFileName overrides the name of the combined file in the share directory.
+// The default is the megaconfigmap.io/filename label of the megaconfigmap.
+func WithFileName(fileName string) Option {
+	return func(o *options) {
+		o.fileName = fileName
+	}
+}
+
+// WithFileMode sets the permission bits of the combined file. The default is 0600.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// WithSkipIfCurrent makes the Combiner record what it wrote in a state file in the share directory,
+// and skip downloading when the recorded ID matches the megaconfigmap.
+// If verify is true, the digest of the file on disk is also checked.
+func WithSkipIfCurrent(verify bool) Option {
+	return func(o *options) {
+		o.skipIfCurrent = true
+		o.verifyCached = verify
+	}
+}
+
+// WithCacheEndpoint makes the Combiner download the megaconfigmap from the node-local cache at endpoint,
+// which is unix:///PATH or https://HOST:PORT. The Combiner falls back to the API server if the cache fails.
+func WithCacheEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.cacheEndpoint = endpoint
+	}
+}
+
+// WithCacheToken sets the bearer token presented to the node-local cache.
+// The default is the token of the loaded config, so it is needed only with WithClient.
+func WithCacheToken(token string) Option {
+	return func(o *options) {
+		o.cacheToken = token
+	}
+}
+
+// WithCacheTLS makes the Combiner verify a node-local cache at an https endpoint with the CA certificates in caFile,
+// and serverName instead of the host of the endpoint. Empty values mean the system roots and the host.
+func WithCacheTLS(caFile, serverName string) Option {
+	return func(o *options) {
+		o.cacheCAFile = caFile
+		o.cacheServerName = serverName
+	}
+}
+
 // NewCombiner creates a Combiner instance
-func NewCombiner(megaConfigMapName, shareDir string) (*Combiner, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
+func NewCombiner(megaConfigMapName, shareDir string, opts ...Option) (*Combiner, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
+	clientset, namespace, cacheToken := o.client, o.namespace, o.cacheToken
+	if clientset == nil {
+		config, ns, err := loadConfig(o)
+		if err != nil {
+			return nil, newError(ReasonInvalidConfig, err)
+		}
+		clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, newError(ReasonInvalidConfig, err)
+		}
+		namespace = ns
+		if len(cacheToken) == 0 && len(o.cacheEndpoint) > 0 {
+			cacheToken = bearerToken(config)
+		}
+	}
+	if len(namespace) == 0 {
+		return nil, newError(ReasonInvalidConfig, errors.New("namespace is not specified"))
 	}
 	return &Combiner{
 		megaConfigMapName: megaConfigMapName,
+		namespace:         namespace,
 		shareDir:          shareDir,
+		fileName:          o.fileName,
+		fileMode:          o.fileMode,
+		skipIfCurrent:     o.skipIfCurrent,
+		verifyCached:      o.verifyCached,
+		cacheEndpoint:     o.cacheEndpoint,
+		cacheToken:        cacheToken,
+		cacheCAFile:       o.cacheCAFile,
+		cacheServerName:   o.cacheServerName,
 		k8s:               clientset,
 	}, nil
 }
 
+// loadConfig returns the REST config and the namespace.
+// It prefers the in-cluster config unless a kubeconfig or a context is given, or it runs out of cluster.
+func loadConfig(o options) (*rest.Config, string, error) {
+	if len(o.kubeconfig) == 0 && len(o.context) == 0 {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			namespace := o.namespace
+			if len(namespace) == 0 {
+				namespaceBytes, err := ioutil.ReadFile(namespaceFile)
+				if err != nil {
+					return nil, "", fmt.Errorf("failed to get current context namespace; %w", err)
+				}
+				namespace = string(namespaceBytes)
+			}
+			return config, namespace, nil
+		}
+		if err != rest.ErrNotInCluster {
+			return nil, "", err
+		}
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: o.context})
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace := o.namespace
+	if len(namespace) == 0 {
+		namespace, _, err = clientConfig.Namespace()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return config, namespace, nil
+}
+
 // MapID returns a hash string
 func MapID(data []byte, namespace, name string) string {
-	h := sha1.New()
+	h := newIDHash(namespace, name)
 	h.Write(data)
-	h.Write([]byte(namespace))
-	h.Write([]byte(name))
-	bs := h.Sum(nil)
-	return fmt.Sprintf("%x", bs)
+	return h.ID()
+}
+
+// idHash computes MapID of the data written to it
+type idHash struct {
+	hash.Hash
+	namespace string
+	name      string
+}
+
+func newIDHash(namespace, name string) *idHash {
+	return &idHash{Hash: sha1.New(), namespace: namespace, name: name}
+}
+
+// ID returns MapID of the data written so far
+func (h *idHash) ID() string {
+	h.Write([]byte(h.namespace))
+	h.Write([]byte(h.name))
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
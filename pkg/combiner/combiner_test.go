@@ -1,11 +1,19 @@
 package combiner
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestCombiner_sortContents(t *testing.T) {
@@ -57,6 +65,7 @@ func TestCombiner_sortContents(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
+		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			c := &Combiner{}
@@ -71,3 +80,138 @@ func TestCombiner_sortContents(t *testing.T) {
 		})
 	}
 }
+
+func TestReasonOf(t *testing.T) {
+	configmaps := schema.GroupResource{Resource: "configmaps"}
+	tests := []struct {
+		name string
+		err  error
+		want Reason
+	}{
+		{
+			name: "not found",
+			err:  fmt.Errorf("failed to get megaconfigmap; %w", apierrors.NewNotFound(configmaps, "my-conf")),
+			want: ReasonNotFound,
+		},
+		{
+			name: "forbidden",
+			err:  fmt.Errorf("failed to list configmaps; %w", apierrors.NewForbidden(configmaps, "", errors.New("rbac"))),
+			want: ReasonForbidden,
+		},
+		{
+			name: "other api error",
+			err:  apierrors.NewInternalError(errors.New("etcd")),
+			want: ReasonAPI,
+		},
+		{
+			name: "checksum mismatch",
+			err:  fmt.Errorf("wrapped; %w", newError(ReasonChecksumMismatch, errors.New("mismatch"))),
+			want: ReasonChecksumMismatch,
+		},
+		{
+			name: "unknown",
+			err:  errors.New("unknown"),
+			want: ReasonUnknown,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := ReasonOf(tt.err); got != tt.want {
+				t.Errorf("ReasonOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombiner_Run_getByName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combiner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k8s := fake.NewSimpleClientset(newMegaConfigMap("abcdefg", 3)...)
+	c, err := NewCombiner("my-conf", dir, WithClient(k8s), WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Chunks != 3 || result.Bytes != 7 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	for _, action := range k8s.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("combiner needs %s on %s; only get is expected", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestCombiner_Run_pending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combiner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	objects := newMegaConfigMap("abcdefg", 3)
+	objects[0].(*corev1.ConfigMap).Annotations = map[string]string{PendingAnnotation: "next-id"}
+	k8s := fake.NewSimpleClientset(objects...)
+	c, err := NewCombiner("my-conf", dir, WithClient(k8s), WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Run()
+	if ReasonOf(err) != ReasonNotFound {
+		t.Errorf("Run() error = %v, want %s", err, ReasonNotFound)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data")); !os.IsNotExist(err) {
+		t.Error("file of a pending megaconfigmap is written")
+	}
+}
+
+func TestCombiner_Run_fileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combiner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k8s := fake.NewSimpleClientset(newMegaConfigMap("abcdefg", 3)...)
+	c, err := NewCombiner("my-conf", dir, WithClient(k8s), WithNamespace("default"), WithFileMode(0644))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	info, err := os.Stat(result.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+}
+
+func TestCombiner_MasterUID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combiner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	objects := newMegaConfigMap("abcdefg", 3)
+	objects[0].(*corev1.ConfigMap).UID = "my-uid"
+	c, err := NewCombiner("my-conf", dir, WithClient(fake.NewSimpleClientset(objects...)), WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if c.MasterUID() != "my-uid" {
+		t.Errorf("MasterUID() = %q, want my-uid", c.MasterUID())
+	}
+}
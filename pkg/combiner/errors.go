@@ -0,0 +1,78 @@
+package combiner
+
+import (
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Reason classifies a combiner failure
+type Reason string
+
+const (
+	// ReasonUnknown is used for errors that do not fall into any other class
+	ReasonUnknown Reason = "Unknown"
+	// ReasonInvalidConfig means the combiner itself is misconfigured
+	ReasonInvalidConfig Reason = "InvalidConfig"
+	// ReasonNotFound means the megaconfigmap or one of its partials does not exist
+	ReasonNotFound Reason = "NotFound"
+	// ReasonForbidden means the service account is not allowed to read configmaps
+	ReasonForbidden Reason = "Forbidden"
+	// ReasonInvalidConfigMap means a megaconfigmap or partial configmap is malformed
+	ReasonInvalidConfigMap Reason = "InvalidConfigMap"
+	// ReasonChecksumMismatch means the combined data does not match the megaconfigmap ID
+	ReasonChecksumMismatch Reason = "ChecksumMismatch"
+	// ReasonIO means reading or writing the share directory failed
+	ReasonIO Reason = "IOError"
+	// ReasonInsufficientStorage means the share directory does not have enough free space
+	ReasonInsufficientStorage Reason = "InsufficientStorage"
+	// ReasonAPI means the API server returned an unexpected error
+	ReasonAPI Reason = "APIError"
+)
+
+// Error is an error annotated with its Reason
+type Error struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(reason Reason, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Reason: reason, Err: err}
+}
+
+// ReasonOf returns the Reason of err.
+// Kubernetes API errors are classified by their status even if they are wrapped.
+func ReasonOf(err error) Reason {
+	if err == nil {
+		return ""
+	}
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		statusErr, ok := apiStatus.(error)
+		switch {
+		case ok && apierrors.IsNotFound(statusErr):
+			return ReasonNotFound
+		case ok && (apierrors.IsForbidden(statusErr) || apierrors.IsUnauthorized(statusErr)):
+			return ReasonForbidden
+		default:
+			return ReasonAPI
+		}
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return ReasonUnknown
+}
@@ -0,0 +1,155 @@
+package combiner
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ManifestKey is the configmap key of the megaconfigmap to store its Manifest
+const ManifestKey = "manifest"
+
+// EncodingGzip means the partial configmaps hold the gzip compressed file
+const EncodingGzip = "gzip"
+
+// Manifest describes the content of a megaconfigmap and its partial configmaps
+type Manifest struct {
+	// Bytes is the size of the combined file
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex digest of the combined file
+	SHA256 string `json:"sha256"`
+	// Encoding is how the combined file is encoded in the partial configmaps. Empty means as is.
+	Encoding string `json:"encoding,omitempty"`
+	// Chunks lists the partial configmaps in order
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk describes a partial configmap
+type Chunk struct {
+	// Name is the name of the partial configmap
+	Name string `json:"name"`
+	// Bytes is the size of the partial data as stored
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex digest of the partial data as stored
+	SHA256 string `json:"sha256"`
+}
+
+// PartialName returns the name of the order-th partial configmap of the megaconfigmap
+func PartialName(megaConfigMapName string, order int64) string {
+	return fmt.Sprintf("%s-%d", megaConfigMapName, order)
+}
+
+// NewManifest splits data into blocks of blockBytes and describes them
+func NewManifest(data []byte, megaConfigMapName string, blockBytes int64) *Manifest {
+	return NewEncodedManifest(data, data, "", megaConfigMapName, blockBytes)
+}
+
+// NewEncodedManifest describes content stored as data encoded by encoding, split into blocks of blockBytes
+func NewEncodedManifest(content, data []byte, encoding string, megaConfigMapName string, blockBytes int64) *Manifest {
+	var chunks [][]byte
+	for i := int64(0); i*blockBytes < int64(len(data)); i++ {
+		end := (i + 1) * blockBytes
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		chunks = append(chunks, data[i*blockBytes:end])
+	}
+	return NewChunkedManifest(content, chunks, encoding, megaConfigMapName)
+}
+
+// NewChunkedManifest describes content stored as chunks encoded by encoding. The chunks may differ in size.
+func NewChunkedManifest(content []byte, chunks [][]byte, encoding string, megaConfigMapName string) *Manifest {
+	m := &Manifest{
+		Bytes:    int64(len(content)),
+		SHA256:   fmt.Sprintf("%x", sha256.Sum256(content)),
+		Encoding: encoding,
+		Chunks:   []Chunk{},
+	}
+	for i, chunk := range chunks {
+		m.Chunks = append(m.Chunks, Chunk{
+			Name:   PartialName(megaConfigMapName, int64(i)),
+			Bytes:  int64(len(chunk)),
+			SHA256: fmt.Sprintf("%x", sha256.Sum256(chunk)),
+		})
+	}
+	return m
+}
+
+// Validate checks that the Manifest is consistent
+func (m *Manifest) Validate() error {
+	var total int64
+	for i, c := range m.Chunks {
+		if len(c.Name) == 0 {
+			return fmt.Errorf("chunk %d has no name", i)
+		}
+		if c.Bytes < 0 {
+			return fmt.Errorf("chunk %d has negative size", i)
+		}
+		if len(c.SHA256) != sha256.Size*2 {
+			return fmt.Errorf("chunk %d has invalid sha256 %q", i, c.SHA256)
+		}
+		total += c.Bytes
+	}
+	switch m.Encoding {
+	case "":
+		if total != m.Bytes {
+			return fmt.Errorf("total size %d does not match the sum of chunks %d", m.Bytes, total)
+		}
+	case EncodingGzip:
+	default:
+		return fmt.Errorf("unknown encoding %q", m.Encoding)
+	}
+	if len(m.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("invalid sha256 %q", m.SHA256)
+	}
+	return nil
+}
+
+// StoredBytes returns the total size of the partial data as stored
+func (m *Manifest) StoredBytes() int64 {
+	var total int64
+	for _, c := range m.Chunks {
+		total += c.Bytes
+	}
+	return total
+}
+
+// PartialData returns the data of the partial configmap.
+// Chunks that are not valid UTF-8 are stored in binaryData.
+func PartialData(cm *corev1.ConfigMap) ([]byte, bool) {
+	if data, ok := cm.Data[PartialItemKey]; ok {
+		return []byte(data), true
+	}
+	data, ok := cm.BinaryData[PartialItemKey]
+	return data, ok
+}
+
+// Marshal encodes the Manifest to store it in a megaconfigmap
+func (m *Manifest) Marshal() (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ManifestOf returns the Manifest stored in the megaconfigmap.
+// It returns nil without error if the megaconfigmap was created by an older version and has no manifest.
+func ManifestOf(master *corev1.ConfigMap) (*Manifest, error) {
+	data, ok := master.Data[ManifestKey]
+	if !ok {
+		return nil, nil
+	}
+	var m Manifest
+	err := json.Unmarshal([]byte(data), &m)
+	if err != nil {
+		return nil, newError(ReasonInvalidConfigMap, fmt.Errorf("malformed manifest in megaconfigmap %s; %w", master.Name, err))
+	}
+	err = m.Validate()
+	if err != nil {
+		return nil, newError(ReasonInvalidConfigMap, fmt.Errorf("malformed manifest in megaconfigmap %s; %w", master.Name, err))
+	}
+	return &m, nil
+}
@@ -0,0 +1,83 @@
+package combiner
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewManifest(t *testing.T) {
+	m := NewManifest([]byte("abcdefg"), "my-conf", 3)
+	if m.Bytes != 7 {
+		t.Errorf("Bytes = %d, want 7", m.Bytes)
+	}
+	var names []string
+	var sizes []int64
+	for _, c := range m.Chunks {
+		names = append(names, c.Name)
+		sizes = append(sizes, c.Bytes)
+	}
+	if want := []string{"my-conf-0", "my-conf-1", "my-conf-2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("chunk names = %v, want %v", names, want)
+	}
+	if want := []int64{3, 3, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("chunk sizes = %v, want %v", sizes, want)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestManifestOf(t *testing.T) {
+	valid, err := NewManifest([]byte("abc"), "my-conf", 2).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    map[string]string
+		wantNil bool
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: map[string]string{ManifestKey: valid},
+		},
+		{
+			name:    "no manifest",
+			data:    nil,
+			wantNil: true,
+		},
+		{
+			name:    "invalid: not json",
+			data:    map[string]string{ManifestKey: "{"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid: size mismatch",
+			data:    map[string]string{ManifestKey: `{"bytes":10,"sha256":"","chunks":[]}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ManifestOf(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "my-conf"}, Data: tt.data})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ManifestOf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if ReasonOf(err) != ReasonInvalidConfigMap {
+					t.Errorf("ReasonOf() = %v, want %v", ReasonOf(err), ReasonInvalidConfigMap)
+				}
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("ManifestOf() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package combiner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// preflight checks that the share directory can hold the combined file before any partial configmap is fetched.
+// The free space is checked only if manifest is given.
+func (c *Combiner) preflight(fileName string, manifest *Manifest) error {
+	tmp, err := ioutil.TempFile(c.shareDir, ".megaconfigmap-preflight")
+	if err != nil {
+		return newError(ReasonIO, fmt.Errorf("share directory %s is not writable; %w", c.shareDir, err))
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	path := filepath.Join(c.shareDir, fileName)
+	stat, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return newError(ReasonIO, err)
+	case !stat.Mode().IsRegular():
+		return newError(ReasonIO, fmt.Errorf("%s already exists and is not a regular file", path))
+	}
+
+	if manifest == nil {
+		return nil
+	}
+	free, err := freeBytes(c.shareDir)
+	if err != nil {
+		return newError(ReasonIO, fmt.Errorf("failed to get free space of %s; %w", c.shareDir, err))
+	}
+	need := manifest.Bytes
+	if len(manifest.Encoding) > 0 {
+		// the encoded file is decoded to another temporary file
+		need += manifest.StoredBytes()
+	}
+	if free >= 0 && free < need {
+		return newError(ReasonInsufficientStorage,
+			fmt.Errorf("need %s, have %s on %s", FormatBytes(need), FormatBytes(free), c.shareDir))
+	}
+	return nil
+}
+
+// FormatBytes formats n in binary units, e.g. 1.8GiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,46 @@
+package combiner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCombiner_preflight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "conflict"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := &Combiner{shareDir: dir}
+
+	if err := c.preflight("data", &Manifest{Bytes: 1}); err != nil {
+		t.Errorf("preflight() error = %v", err)
+	}
+	if err := c.preflight("conflict", nil); ReasonOf(err) != ReasonIO {
+		t.Errorf("preflight() error = %v, want %v", err, ReasonIO)
+	}
+	if err := c.preflight("data", &Manifest{Bytes: 1 << 62}); ReasonOf(err) != ReasonInsufficientStorage {
+		t.Errorf("preflight() error = %v, want %v", err, ReasonInsufficientStorage)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 512, want: "512B"},
+		{n: 512 * 1024 * 1024, want: "512.0MiB"},
+		{n: 1932735283, want: "1.8GiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
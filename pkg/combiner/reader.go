@@ -0,0 +1,273 @@
+package combiner
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultCacheChunks is the default number of chunks cached by a ChunkReader
+const DefaultCacheChunks = 16
+
+// Open combines the megaconfigmap namespace/name into a temporary file, and returns the file after verifying it.
+// Closing the returned reader removes the file.
+func Open(ctx context.Context, k8s kubernetes.Interface, namespace, name string) (io.ReadCloser, error) {
+	c := &Combiner{megaConfigMapName: name, namespace: namespace, shareDir: os.TempDir(), k8s: k8s}
+	master, manifest, err := c.getMaster()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	id := master.Labels[IDLabel]
+	tempFileName, _, err := c.assemble(id, manifest)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.verifyFile(tempFileName, id)
+	if err != nil {
+		os.Remove(tempFileName)
+		return nil, err
+	}
+	f, err := os.Open(tempFileName)
+	if err != nil {
+		os.Remove(tempFileName)
+		return nil, newError(ReasonIO, err)
+	}
+	return &tempFile{File: f}, nil
+}
+
+// tempFile is removed when it is closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// ChunkReader reads a megaconfigmap at random, fetching partial configmaps as they are read.
+// Each chunk is verified against the manifest of the megaconfigmap, and recently read chunks are cached.
+// It is safe for concurrent use.
+type ChunkReader struct {
+	ctx     context.Context
+	k8s     kubernetes.Interface
+	master  *corev1.ConfigMap
+	chunks  []Chunk
+	offsets []int64
+	size    int64
+
+	mu    sync.Mutex
+	cache *chunkCache
+}
+
+// NewChunkReader returns a ChunkReader of the megaconfigmap namespace/name caching up to cacheChunks chunks.
+// Compressed megaconfigmaps cannot be read at random; use Open for them.
+// Megaconfigmaps created by older versions have no manifest, so they are read and verified at once.
+func NewChunkReader(ctx context.Context, k8s kubernetes.Interface, namespace, name string, cacheChunks int) (*ChunkReader, error) {
+	if cacheChunks <= 0 {
+		return nil, newError(ReasonInvalidConfig, errors.New("cacheChunks must be positive"))
+	}
+	c := &Combiner{megaConfigMapName: name, namespace: namespace, k8s: k8s}
+	master, manifest, err := c.getMaster()
+	if err != nil {
+		return nil, err
+	}
+	r := &ChunkReader{ctx: ctx, k8s: k8s, master: master, cache: newChunkCache(cacheChunks)}
+	if manifest == nil {
+		return r, r.loadAll(c)
+	}
+	if len(manifest.Encoding) > 0 {
+		return nil, newError(ReasonInvalidConfigMap, fmt.Errorf("megaconfigmap %s is encoded by %s and cannot be read at random", name, manifest.Encoding))
+	}
+	r.setChunks(manifest.Chunks)
+	return r, nil
+}
+
+// ID returns the megaconfigmap ID of the content
+func (r *ChunkReader) ID() string {
+	return r.master.Labels[IDLabel]
+}
+
+// Size returns the size of the content
+func (r *ChunkReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt
+func (r *ChunkReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(r.offsets), func(i int) bool { return r.offsets[i] > pos }) - 1
+		data, err := r.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-r.offsets[i]:])
+	}
+	return n, nil
+}
+
+func (r *ChunkReader) setChunks(chunks []Chunk) {
+	r.chunks = chunks
+	r.offsets = make([]int64, len(chunks))
+	r.size = 0
+	for i, chunk := range chunks {
+		r.offsets[i] = r.size
+		r.size += chunk.Bytes
+	}
+}
+
+// loadAll reads all partial configmaps into the cache and verifies them against the ID
+func (r *ChunkReader) loadAll(c *Combiner) error {
+	configmaps, err := c.k8s.CoreV1().ConfigMaps(c.namespace).List(
+		metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s!=true", IDLabel, r.ID(), MasterLabel)})
+	if err != nil {
+		return fmt.Errorf("failed to list configmaps; %w", err)
+	}
+	contents, err := c.sortContents(configmaps)
+	if err != nil {
+		return newError(ReasonInvalidConfigMap, err)
+	}
+	h := newIDHash(c.namespace, c.megaConfigMapName)
+	chunks := make([]Chunk, len(contents))
+	r.cache = newChunkCache(len(contents))
+	for i, content := range contents {
+		h.Write([]byte(content))
+		chunks[i] = Chunk{Name: PartialName(c.megaConfigMapName, int64(i)), Bytes: int64(len(content))}
+		r.cache.add(i, []byte(content))
+	}
+	if actual := h.ID(); actual != r.ID() {
+		return newError(ReasonChecksumMismatch,
+			fmt.Errorf("checksum is not matched. checksumInLabel:%s, checksumActual:%s", r.ID(), actual))
+	}
+	r.setChunks(chunks)
+	return nil
+}
+
+// chunk returns the data of the i-th chunk from the cache or the API server
+func (r *ChunkReader) chunk(i int) ([]byte, error) {
+	r.mu.Lock()
+	data, ok := r.cache.get(i)
+	r.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := getChunk(r.k8s, r.master.Namespace, r.ID(), r.chunks[i])
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cache.add(i, data)
+	r.mu.Unlock()
+	return data, nil
+}
+
+// getChunk gets the partial configmap of chunk by its name and verifies it against the manifest
+func getChunk(k8s kubernetes.Interface, namespace, id string, chunk Chunk) ([]byte, error) {
+	cm, err := k8s.CoreV1().ConfigMaps(namespace).Get(chunk.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partial configmap %s; %w", chunk.Name, err)
+	}
+	return VerifyChunk(cm, id, chunk)
+}
+
+// VerifyChunk returns the data of the partial configmap cm if it belongs to the megaconfigmap id and matches chunk
+func VerifyChunk(cm *corev1.ConfigMap, id string, chunk Chunk) ([]byte, error) {
+	if cm.Labels[IDLabel] != id {
+		return nil, newError(ReasonInvalidConfigMap,
+			fmt.Errorf("partial configmap %s belongs to %s, want %s", chunk.Name, cm.Labels[IDLabel], id))
+	}
+	data, ok := PartialData(cm)
+	if !ok {
+		return nil, newError(ReasonInvalidConfigMap, fmt.Errorf("partial-item is not found in configmap %s", chunk.Name))
+	}
+	if int64(len(data)) != chunk.Bytes || fmt.Sprintf("%x", sha256.Sum256(data)) != chunk.SHA256 {
+		return nil, newError(ReasonChecksumMismatch, fmt.Errorf("partial configmap %s does not match the manifest", chunk.Name))
+	}
+	return data, nil
+}
+
+// getMaster returns the megaconfigmap and its manifest. Megaconfigmaps being uploaded are not found.
+func (c *Combiner) getMaster() (*corev1.ConfigMap, *Manifest, error) {
+	master, err := c.k8s.CoreV1().ConfigMaps(c.namespace).Get(c.megaConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get megaconfigmap %s; %w", c.megaConfigMapName, err)
+	}
+	c.mu.Lock()
+	c.masterUID = master.UID
+	c.mu.Unlock()
+	if len(master.Labels[IDLabel]) == 0 {
+		return nil, nil, newError(ReasonInvalidConfigMap, errors.New(IDLabel+" is not found in megaconfigmap "+c.megaConfigMapName))
+	}
+	if len(master.Annotations[PendingAnnotation]) > 0 {
+		return nil, nil, newError(ReasonNotFound, fmt.Errorf("upload of megaconfigmap %s is not complete", c.megaConfigMapName))
+	}
+	manifest, err := ManifestOf(master)
+	if err != nil {
+		return nil, nil, err
+	}
+	return master, manifest, nil
+}
+
+// chunkCache is an LRU cache of chunks
+type chunkCache struct {
+	max   int
+	ll    *list.List
+	items map[int]*list.Element
+}
+
+type cachedChunk struct {
+	index int
+	data  []byte
+}
+
+func newChunkCache(max int) *chunkCache {
+	return &chunkCache{max: max, ll: list.New(), items: make(map[int]*list.Element)}
+}
+
+func (c *chunkCache) get(i int) ([]byte, bool) {
+	e, ok := c.items[i]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cachedChunk).data, true
+}
+
+func (c *chunkCache) add(i int, data []byte) {
+	if e, ok := c.items[i]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[i] = c.ll.PushFront(&cachedChunk{index: i, data: data})
+	for c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedChunk).index)
+	}
+}
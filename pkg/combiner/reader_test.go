@@ -0,0 +1,147 @@
+package combiner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newMegaConfigMap returns a master with a manifest and its partial configmaps.
+// Tests out of this package use megaconfigmaptest.ConfigMaps, which cannot be imported here since it imports combiner.
+func newMegaConfigMap(data string, blockBytes int) []runtime.Object {
+	id := MapID([]byte(data), "default", "my-conf")
+	manifest, err := NewManifest([]byte(data), "my-conf", int64(blockBytes)).Marshal()
+	if err != nil {
+		panic(err)
+	}
+	objects := []runtime.Object{&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "my-conf", Labels: map[string]string{
+			IDLabel: id, FileNameLabel: "data", MasterLabel: "true",
+		}},
+		Data: map[string]string{ManifestKey: manifest},
+	}}
+	for i := 0; i*blockBytes < len(data); i++ {
+		end := (i + 1) * blockBytes
+		if end > len(data) {
+			end = len(data)
+		}
+		objects = append(objects, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: PartialName("my-conf", int64(i)), Labels: map[string]string{
+				IDLabel: id, OrderLabel: strconv.Itoa(i),
+			}},
+			Data: map[string]string{PartialItemKey: data[i*blockBytes : end]},
+		})
+	}
+	return objects
+}
+
+func TestOpen(t *testing.T) {
+	k8s := fake.NewSimpleClientset(newMegaConfigMap("abcdefg", 3)...)
+	rc, err := Open(context.Background(), k8s, "default", "my-conf")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcdefg" {
+		t.Errorf("read %q", data)
+	}
+	name := rc.(*tempFile).Name()
+	rc.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("%s is not removed", name)
+	}
+
+	p, err := k8s.CoreV1().ConfigMaps("default").Get("my-conf-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Data[PartialItemKey] = "DEF"
+	if _, err := k8s.CoreV1().ConfigMaps("default").Update(p); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(context.Background(), k8s, "default", "my-conf")
+	if ReasonOf(err) != ReasonChecksumMismatch {
+		t.Errorf("Open() error = %v, want %s", err, ReasonChecksumMismatch)
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	k8s := fake.NewSimpleClientset(newMegaConfigMap("abcdefghij", 3)...)
+	r, err := NewChunkReader(context.Background(), k8s, "default", "my-conf", 2)
+	if err != nil {
+		t.Fatalf("NewChunkReader() error = %v", err)
+	}
+	if r.Size() != 10 {
+		t.Errorf("Size() = %d", r.Size())
+	}
+	countGets := func() int {
+		n := 0
+		for _, a := range k8s.Actions() {
+			if a.GetVerb() == "get" {
+				n++
+			}
+		}
+		return n
+	}
+
+	buf := make([]byte, 5)
+	n, err := r.ReadAt(buf[:4], 2)
+	if err != nil || string(buf[:n]) != "cdef" {
+		t.Errorf("ReadAt(2) = %q, %v", buf[:n], err)
+	}
+	gets := countGets()
+	// chunks 0 and 1 are cached
+	n, err = r.ReadAt(buf[:3], 0)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("ReadAt(0) = %q, %v", buf[:n], err)
+	}
+	if countGets() != gets {
+		t.Error("cached chunk is fetched again")
+	}
+	n, err = r.ReadAt(buf, 8)
+	if n != 2 || string(buf[:n]) != "ij" || err == nil {
+		t.Errorf("ReadAt(8) = %q, %v; want ij, EOF", buf[:n], err)
+	}
+
+	p, err := k8s.CoreV1().ConfigMaps("default").Get("my-conf-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Data[PartialItemKey] = "DEF"
+	if _, err := k8s.CoreV1().ConfigMaps("default").Update(p); err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewChunkReader(context.Background(), k8s, "default", "my-conf", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.ReadAt(buf, 3)
+	if ReasonOf(err) != ReasonChecksumMismatch {
+		t.Errorf("ReadAt() error = %v, want %s", err, ReasonChecksumMismatch)
+	}
+}
+
+func TestChunkReader_noManifest(t *testing.T) {
+	objects := newMegaConfigMap("abcdefg", 7)
+	delete(objects[0].(*corev1.ConfigMap).Data, ManifestKey)
+	k8s := fake.NewSimpleClientset(objects...)
+	r, err := NewChunkReader(context.Background(), k8s, "default", "my-conf", 1)
+	if err != nil {
+		t.Fatalf("NewChunkReader() error = %v", err)
+	}
+	buf := make([]byte, 7)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil || string(buf[:n]) != "abcdefg" {
+		t.Errorf("ReadAt() = %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,170 @@
+package combiner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	syncRetryInterval    = time.Second
+	maxSyncRetryInterval = time.Minute
+)
+
+// Server serves the combined file over HTTP.
+// Responses carry the megaconfigmap ID as ETag, and support Range and If-None-Match requests.
+// /healthz is the liveness endpoint, and /readyz succeeds once the file is combined.
+type Server struct {
+	combiner *Combiner
+
+	mu      sync.RWMutex
+	current *servedFile
+}
+
+// servedFile is a combined file kept open while it is served.
+// refs counts the Server and the responses reading it, and the file is closed when it drops to 0.
+type servedFile struct {
+	id      string
+	name    string
+	file    *os.File
+	size    int64
+	modTime time.Time
+	refs    int32
+}
+
+func (f *servedFile) acquire() {
+	atomic.AddInt32(&f.refs, 1)
+}
+
+func (f *servedFile) release() {
+	if atomic.AddInt32(&f.refs, -1) == 0 {
+		f.file.Close()
+	}
+}
+
+// NewServer creates a Server serving the megaconfigmap of c
+func NewServer(c *Combiner) *Server {
+	return &Server{combiner: c}
+}
+
+// Sync combines the megaconfigmap and starts serving it.
+// Responses in flight keep reading the previous version.
+func (s *Server) Sync() (*Result, error) {
+	result, err := s.combiner.Run()
+	if err != nil {
+		return nil, err
+	}
+	// The file may be replaced by the next Sync, so it is kept open while it is served.
+	// The previous file is closed after the responses reading it finish.
+	f, err := os.Open(result.Path)
+	if err != nil {
+		return nil, newError(ReasonIO, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, newError(ReasonIO, err)
+	}
+	s.mu.Lock()
+	previous := s.current
+	s.current = &servedFile{
+		id:      result.ID,
+		name:    filepath.Base(result.Path),
+		file:    f,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		refs:    1,
+	}
+	s.mu.Unlock()
+	if previous != nil {
+		previous.release()
+	}
+	return result, nil
+}
+
+// ID returns the ID of the served megaconfigmap, or an empty string before the first Sync
+func (s *Server) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.current == nil {
+		return ""
+	}
+	return s.current.id
+}
+
+// Watch calls Sync whenever the megaconfigmap is updated until ctx is done.
+// A failed Sync is retried with exponential backoff until it succeeds or the megaconfigmap is updated again,
+// since the megaconfigmap may become combinable without a new version, e.g. when its upload completes.
+// onSync is called with the result of each Sync.
+func (s *Server) Watch(ctx context.Context, onSync func(*Result, error)) {
+	updates := Watch(ctx, s.combiner.k8s, s.combiner.namespace, s.combiner.megaConfigMapName)
+	var (
+		retry    <-chan time.Time
+		interval time.Duration
+	)
+	for {
+		select {
+		case id, ok := <-updates:
+			if !ok {
+				return
+			}
+			if id == s.ID() {
+				retry = nil
+				continue
+			}
+			interval = syncRetryInterval
+		case <-retry:
+		}
+		result, err := s.Sync()
+		onSync(result, err)
+		if err != nil {
+			retry = time.After(interval)
+			interval *= 2
+			if interval > maxSyncRetryInterval {
+				interval = maxSyncRetryInterval
+			}
+		} else {
+			retry = nil
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.WriteHeader(http.StatusOK)
+		return
+	case "/readyz":
+		if len(s.ID()) == 0 {
+			http.Error(w, "megaconfigmap is not combined yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	s.mu.RLock()
+	current := s.current
+	if current != nil {
+		current.acquire()
+	}
+	s.mu.RUnlock()
+	if current == nil {
+		http.Error(w, "megaconfigmap is not combined yet", http.StatusServiceUnavailable)
+		return
+	}
+	defer current.release()
+	if r.URL.Path != "/" && r.URL.Path != "/"+current.name {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"`+current.id+`"`)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, current.name, current.modTime, io.NewSectionReader(current.file, 0, current.size))
+}
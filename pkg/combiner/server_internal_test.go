@@ -0,0 +1,61 @@
+package combiner
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServer_Sync_closePrevious(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k8s := fake.NewSimpleClientset()
+	put := func(data string) {
+		for _, obj := range newMegaConfigMap(data, 3) {
+			cm := obj.(*corev1.ConfigMap)
+			if err := k8s.Tracker().Update(corev1.SchemeGroupVersion.WithResource("configmaps"), cm, "default"); err != nil {
+				if err := k8s.Tracker().Add(cm); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	put("abcdefg")
+	c, err := NewCombiner("my-conf", dir, WithClient(k8s), WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(c)
+	if _, err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	first := s.current
+	// a response in flight
+	first.acquire()
+	put("hijklmn")
+	if _, err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.file.Stat(); err != nil {
+		t.Errorf("file is closed while it is served: %v", err)
+	}
+	first.release()
+	if _, err := first.file.Stat(); err == nil {
+		t.Error("previous file is not closed after the response finished")
+	}
+
+	second := s.current
+	put("opqrstu")
+	if _, err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.file.Stat(); err == nil {
+		t.Error("previous file is not closed")
+	}
+}
@@ -0,0 +1,172 @@
+package combiner_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster := megaconfigmaptest.New(t)
+	put := func(data string) string {
+		cluster.Put(megaconfigmaptest.LegacyConfigMaps("default", "my-conf", []byte(data), len(data))...)
+		return combiner.MapID([]byte(data), "default", "my-conf")
+	}
+	id := put("abcdefg")
+	k8s := cluster.Fake()
+	c, err := combiner.NewCombiner("my-conf", dir, combiner.WithClient(k8s), combiner.WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := combiner.NewServer(c)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	get := func(path string, header map[string]string) (int, string, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body), resp.Header.Get("ETag")
+	}
+
+	if code, _, _ := get("/healthz", nil); code != http.StatusOK {
+		t.Errorf("/healthz = %d", code)
+	}
+	if code, _, _ := get("/readyz", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before sync = %d", code)
+	}
+	if _, err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := get("/readyz", nil); code != http.StatusOK {
+		t.Errorf("/readyz after sync = %d", code)
+	}
+
+	code, body, etag := get("/data", nil)
+	if code != http.StatusOK || body != "abcdefg" || etag != `"`+id+`"` {
+		t.Errorf("unexpected response: %d %q %s", code, body, etag)
+	}
+	code, body, _ = get("/", map[string]string{"Range": "bytes=2-4"})
+	if code != http.StatusPartialContent || body != "cde" {
+		t.Errorf("unexpected range response: %d %q", code, body)
+	}
+	code, _, _ = get("/", map[string]string{"If-None-Match": `"` + id + `"`})
+	if code != http.StatusNotModified {
+		t.Errorf("status for If-None-Match = %d, want %d", code, http.StatusNotModified)
+	}
+	if code, _, _ := get("/other", nil); code != http.StatusNotFound {
+		t.Errorf("status for other paths = %d", code)
+	}
+
+	watching := make(chan struct{})
+	k8s.PrependWatchReactor("configmaps", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := k8s.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		close(watching)
+		return true, w, err
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	synced := make(chan *combiner.Result)
+	go s.Watch(ctx, func(result *combiner.Result, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		synced <- result
+	})
+	<-watching
+	newID := put("hijklmn")
+	select {
+	case result := <-synced:
+		if result.ID != newID {
+			t.Errorf("synced %s, want %s", result.ID, newID)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("update is not followed")
+	}
+	code, body, etag = get("/", map[string]string{"If-None-Match": `"` + id + `"`})
+	if code != http.StatusOK || body != "hijklmn" || etag != `"`+newID+`"` {
+		t.Errorf("unexpected response after update: %d %q %s", code, body, etag)
+	}
+}
+
+func TestServer_Watch_retry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cms := megaconfigmaptest.ConfigMaps("default", "my-conf", []byte("abcdefg"), 3)
+	cms[0].Annotations = map[string]string{combiner.PendingAnnotation: cms[0].Labels[combiner.IDLabel]}
+	cluster := megaconfigmaptest.New(t)
+	k8s := cluster.Fake()
+	watching := make(chan struct{})
+	k8s.PrependWatchReactor("configmaps", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := k8s.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		close(watching)
+		return true, w, err
+	})
+	c, err := combiner.NewCombiner("my-conf", dir, combiner.WithClient(k8s), combiner.WithNamespace("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := combiner.NewServer(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	synced := make(chan error)
+	go s.Watch(ctx, func(result *combiner.Result, err error) {
+		synced <- err
+	})
+	<-watching
+	cluster.Put(cms...)
+	wait := func() error {
+		select {
+		case err := <-synced:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatal("Sync is not retried")
+			return nil
+		}
+	}
+	if err := wait(); combiner.ReasonOf(err) != combiner.ReasonNotFound {
+		t.Fatalf("Sync() of a pending megaconfigmap error = %v", err)
+	}
+
+	// the upload completes without changing the ID, so only the retry syncs it
+	cms[0].Annotations = nil
+	cluster.Put(cms[0])
+	for err := wait(); err != nil; err = wait() {
+		if combiner.ReasonOf(err) != combiner.ReasonNotFound {
+			t.Fatal(err)
+		}
+	}
+	if s.ID() != cms[0].Labels[combiner.IDLabel] {
+		t.Errorf("ID() = %s after the retry", s.ID())
+	}
+}
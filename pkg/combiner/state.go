@@ -0,0 +1,114 @@
+package combiner
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State records what the Combiner wrote to the share directory last time
+type State struct {
+	// ID is the megaconfigmap ID of the written file
+	ID string `json:"id"`
+	// FileName is the name of the written file in the share directory
+	FileName string `json:"fileName"`
+	// SHA256 is the hex digest of the written file
+	SHA256 string `json:"sha256"`
+	// Bytes is the size of the written file
+	Bytes int64 `json:"bytes"`
+	// WrittenAt is the time the file was written
+	WrittenAt time.Time `json:"writtenAt"`
+}
+
+func (c *Combiner) statePath() string {
+	return filepath.Join(c.shareDir, ".megaconfigmap-"+c.megaConfigMapName+".state")
+}
+
+func (c *Combiner) readState() (*State, error) {
+	data, err := ioutil.ReadFile(c.statePath())
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (c *Combiner) writeState(s *State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.shareDir, ".megaconfigmap-state")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.statePath())
+}
+
+// currentState returns the recorded State if the file in the share directory is still the megaconfigmap id.
+// It returns nil if the file must be written again.
+func (c *Combiner) currentState(id, fileName string) (*State, error) {
+	s, err := c.readState()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		// A broken state file only costs a full download
+		return nil, nil
+	}
+	if s.ID != id || s.FileName != fileName {
+		return nil, nil
+	}
+	path := filepath.Join(c.shareDir, fileName)
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newError(ReasonIO, err)
+	}
+	if stat.Size() != s.Bytes {
+		return nil, nil
+	}
+	if !c.verifyCached {
+		return s, nil
+	}
+	digest, err := fileDigest(path)
+	if err != nil {
+		return nil, newError(ReasonIO, err)
+	}
+	if digest != s.SHA256 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,94 @@
+package combiner
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCombiner_currentState(t *testing.T) {
+	content := []byte("hello")
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+	tests := []struct {
+		name         string
+		state        *State
+		content      []byte
+		verifyCached bool
+		wantSkip     bool
+	}{
+		{
+			name:     "up to date",
+			state:    &State{ID: "id1", FileName: "data", SHA256: digest, Bytes: 5},
+			content:  content,
+			wantSkip: true,
+		},
+		{
+			name:     "no state file",
+			content:  content,
+			wantSkip: false,
+		},
+		{
+			name:     "id changed",
+			state:    &State{ID: "id0", FileName: "data", SHA256: digest, Bytes: 5},
+			content:  content,
+			wantSkip: false,
+		},
+		{
+			name:     "file removed",
+			state:    &State{ID: "id1", FileName: "data", SHA256: digest, Bytes: 5},
+			wantSkip: false,
+		},
+		{
+			name:     "size changed",
+			state:    &State{ID: "id1", FileName: "data", SHA256: digest, Bytes: 5},
+			content:  []byte("hello world"),
+			wantSkip: false,
+		},
+		{
+			name:         "corrupted without verification",
+			state:        &State{ID: "id1", FileName: "data", SHA256: digest, Bytes: 5},
+			content:      []byte("HELLO"),
+			verifyCached: false,
+			wantSkip:     true,
+		},
+		{
+			name:         "corrupted with verification",
+			state:        &State{ID: "id1", FileName: "data", SHA256: digest, Bytes: 5},
+			content:      []byte("HELLO"),
+			verifyCached: true,
+			wantSkip:     false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "megaconfigmap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			c := &Combiner{megaConfigMapName: "my-conf", shareDir: dir, skipIfCurrent: true, verifyCached: tt.verifyCached}
+			if tt.state != nil {
+				if err := c.writeState(tt.state); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.content != nil {
+				if err := ioutil.WriteFile(filepath.Join(dir, "data"), tt.content, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := c.currentState("id1", "data")
+			if err != nil {
+				t.Fatalf("currentState() error = %v", err)
+			}
+			if (got != nil) != tt.wantSkip {
+				t.Errorf("currentState() = %v, wantSkip %v", got, tt.wantSkip)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package combiner
+
+// freeBytes returns -1 because the free space is unknown on this platform
+func freeBytes(path string) (int64, error) {
+	return -1, nil
+}
@@ -0,0 +1,72 @@
+package combiner
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const rewatchInterval = 5 * time.Second
+
+// Watch sends the ID of the megaconfigmap namespace/name when it is first seen and whenever it changes,
+// until ctx is done. The channel is closed after ctx is done.
+// Open or NewChunkReader reads the new version.
+func Watch(ctx context.Context, k8s kubernetes.Interface, namespace, name string) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		var last string
+		for {
+			last = watchOnce(ctx, k8s, namespace, name, last, ch)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rewatchInterval):
+			}
+		}
+	}()
+	return ch
+}
+
+// watchOnce sends IDs different from last until the watch is closed, and returns the last sent ID
+func watchOnce(ctx context.Context, k8s kubernetes.Interface, namespace, name, last string, ch chan<- string) string {
+	w, err := k8s.CoreV1().ConfigMaps(namespace).Watch(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		return last
+	}
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return last
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return last
+			}
+			if ev.Type != watch.Added && ev.Type != watch.Modified {
+				continue
+			}
+			cm, ok := ev.Object.(*corev1.ConfigMap)
+			if !ok || cm.Name != name {
+				continue
+			}
+			id := cm.Labels[IDLabel]
+			if len(id) == 0 || id == last {
+				continue
+			}
+			select {
+			case ch <- id:
+				last = id
+			case <-ctx.Done():
+				return last
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/apis/v1alpha1"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// partialEventDelay collects the events of partial configmaps being uploaded into one reconciliation of their megaconfigmap
+const partialEventDelay = time.Second
+
+// Controller watches megaconfigmaps and reconciles them with a Reconciler
+type Controller struct {
+	reconciler *Reconciler
+	queue      workqueue.RateLimitingInterface
+	informers  []cache.SharedIndexInformer
+	dynamic    dynamicinformer.DynamicSharedInformerFactory
+}
+
+// NewController creates a Controller watching namespace. Empty namespace means all namespaces.
+func NewController(k8s kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, resync time.Duration) *Controller {
+	configMapInformer := cache.NewSharedIndexInformer(
+		cache.NewFilteredListWatchFromClient(k8s.CoreV1().RESTClient(), "configmaps", namespace, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = combiner.IDLabel
+		}),
+		&corev1.ConfigMap{}, resync, configMapIndexers)
+	c := &Controller{
+		reconciler: NewReconciler(k8s, dynamicClient, configMapInformer.GetIndexer()),
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "megaconfigmap"),
+		dynamic:    dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, resync, namespace, nil),
+	}
+
+	configMapInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueConfigMap,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueConfigMap(obj) },
+		DeleteFunc: c.enqueueConfigMap,
+	})
+
+	megaConfigMapInformer := c.dynamic.ForResource(v1alpha1.Resource).Informer()
+	megaConfigMapInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+		DeleteFunc: c.enqueue,
+	})
+	c.informers = []cache.SharedIndexInformer{configMapInformer}
+	return c
+}
+
+// Run starts workers and blocks until stopCh is closed
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) error {
+	defer c.queue.ShutDown()
+
+	for _, informer := range c.informers {
+		go informer.Run(stopCh)
+	}
+	c.dynamic.Start(stopCh)
+	synced := []cache.InformerSynced{c.dynamic.ForResource(v1alpha1.Resource).Informer().HasSynced}
+	for _, informer := range c.informers {
+		synced = append(synced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+	return nil
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *Controller) processNextItem() bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.queue.Forget(item)
+		return true
+	}
+	requeue, err := c.reconciler.Reconcile(namespace, name)
+	if err != nil {
+		log.Printf("failed to reconcile megaconfigmap %s: %v", key, err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	c.queue.Forget(item)
+	if requeue > 0 {
+		c.queue.AddAfter(item, requeue)
+	}
+	return true
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("failed to get key of %v: %v", obj, err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// enqueueConfigMap enqueues the megaconfigmap of a master or a partial configmap.
+// Partial configmaps are uploaded in bursts, so their megaconfigmap is reconciled once after partialEventDelay.
+func (c *Controller) enqueueConfigMap(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	for _, key := range megaConfigMapKeys(cm) {
+		if cm.Labels[combiner.MasterLabel] == "true" {
+			c.queue.Add(key)
+		} else {
+			c.queue.AddAfter(key, partialEventDelay)
+		}
+	}
+}
+
+const (
+	// megaConfigMapIndex indexes configmaps by the key of the megaconfigmap they are the master or a partial of
+	megaConfigMapIndex = "megaconfigmap"
+	// idIndex indexes partial configmaps by their namespace and ID
+	idIndex = "id"
+)
+
+// configMapIndexers select the master and the partial configmaps of a megaconfigmap from the informer cache
+var configMapIndexers = cache.Indexers{
+	megaConfigMapIndex: func(obj interface{}) ([]string, error) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return nil, nil
+		}
+		return megaConfigMapKeys(cm), nil
+	},
+	idIndex: func(obj interface{}) ([]string, error) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.Labels[combiner.MasterLabel] == "true" || len(cm.Labels[combiner.IDLabel]) == 0 {
+			return nil, nil
+		}
+		return []string{cm.Namespace + "/" + cm.Labels[combiner.IDLabel]}, nil
+	},
+}
+
+// megaConfigMapKeys returns the keys of the megaconfigmaps cm is the master of, or a partial linked to by
+// an owner reference or combiner.NameLabel
+func megaConfigMapKeys(cm *corev1.ConfigMap) []string {
+	if cm.Labels[combiner.MasterLabel] == "true" {
+		return []string{cm.Namespace + "/" + cm.Name}
+	}
+	var keys []string
+	if name := cm.Labels[combiner.NameLabel]; len(name) > 0 {
+		keys = append(keys, cm.Namespace+"/"+name)
+	}
+	for _, ref := range cm.OwnerReferences {
+		if ref.APIVersion == "v1" && ref.Kind == "ConfigMap" && ref.Name != cm.Labels[combiner.NameLabel] {
+			keys = append(keys, cm.Namespace+"/"+ref.Name)
+		}
+	}
+	return keys
+}
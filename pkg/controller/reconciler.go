@@ -0,0 +1,363 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/apis/v1alpha1"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultPendingTimeout = 5 * time.Minute
+	defaultGracePeriod    = time.Minute
+)
+
+// Reconciler makes a MegaConfigMap custom resource reflect its master and partial configmaps.
+// It creates the MegaConfigMap from the manifest of the master, repairs owner references of the partials,
+// deletes partials of stale versions and reports the status. It reads configmaps from the cache of the Controller.
+// Partials rendered by kubectl megaconfigmap create --dry-run are linked only by combiner.NameLabel,
+// and get their owner references here.
+type Reconciler struct {
+	k8s        kubernetes.Interface
+	dynamic    dynamic.Interface
+	configMaps cache.Indexer
+
+	// PendingTimeout is how long missing partials are regarded as being uploaded
+	PendingTimeout time.Duration
+	// GracePeriod is how long partials of other versions are kept after they are created,
+	// since clients older than the pending annotation upload them before they relabel the master
+	GracePeriod time.Duration
+	now         func() time.Time
+}
+
+// NewReconciler creates a Reconciler. configMaps is the cache of configmaps labeled with combiner.IDLabel,
+// indexed by configMapIndexers.
+func NewReconciler(k8s kubernetes.Interface, dynamicClient dynamic.Interface, configMaps cache.Indexer) *Reconciler {
+	return &Reconciler{
+		k8s:            k8s,
+		dynamic:        dynamicClient,
+		configMaps:     configMaps,
+		PendingTimeout: defaultPendingTimeout,
+		GracePeriod:    defaultGracePeriod,
+		now:            time.Now,
+	}
+}
+
+// Reconcile reconciles the MegaConfigMap namespace/name.
+// It returns a positive duration if the MegaConfigMap should be reconciled again after it.
+func (r *Reconciler) Reconcile(namespace, name string) (time.Duration, error) {
+	obj, exists, err := r.configMaps.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, r.reportMasterNotFound(namespace, name)
+	}
+	master := obj.(*corev1.ConfigMap)
+	if master.Labels[combiner.MasterLabel] != "true" {
+		return 0, nil
+	}
+
+	partials, err := r.partialsOf(master)
+	if err != nil {
+		return 0, err
+	}
+	spec, err := r.specOf(master, partials)
+	if err != nil {
+		return 0, err
+	}
+	mcm, err := r.ensureMegaConfigMap(master, spec)
+	if err != nil {
+		return 0, err
+	}
+
+	status := mcm.Status.DeepCopy()
+	requeue, err := r.syncPartials(master, spec, partials, status)
+	if err != nil {
+		return 0, err
+	}
+	status.ObservedGeneration = mcm.Generation
+	status.ObservedID = spec.ID
+	status.SetCondition(v1alpha1.ConditionMasterFound, metav1.ConditionTrue, "Found", "")
+	return requeue, r.updateStatus(mcm, status)
+}
+
+// partialsOf returns the partial configmaps linked to master or labeled with its ID or the ID being uploaded
+func (r *Reconciler) partialsOf(master *corev1.ConfigMap) ([]corev1.ConfigMap, error) {
+	keys := []struct{ index, value string }{
+		{megaConfigMapIndex, master.Namespace + "/" + master.Name},
+		{idIndex, master.Namespace + "/" + master.Labels[combiner.IDLabel]},
+	}
+	if pendingID := master.Annotations[combiner.PendingAnnotation]; len(pendingID) > 0 {
+		keys = append(keys, struct{ index, value string }{idIndex, master.Namespace + "/" + pendingID})
+	}
+	seen := make(map[string]bool)
+	var partials []corev1.ConfigMap
+	for _, key := range keys {
+		objs, err := r.configMaps.ByIndex(key.index, key.value)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			p := obj.(*corev1.ConfigMap)
+			if seen[p.Name] || p.Labels[combiner.MasterLabel] == "true" {
+				continue
+			}
+			seen[p.Name] = true
+			partials = append(partials, *p)
+		}
+	}
+	return partials, nil
+}
+
+// specOf builds the spec from the manifest of master.
+// Megaconfigmaps created by older versions have no manifest, so their chunks are discovered by the labels.
+func (r *Reconciler) specOf(master *corev1.ConfigMap, partials []corev1.ConfigMap) (v1alpha1.MegaConfigMapSpec, error) {
+	manifest, err := combiner.ManifestOf(master)
+	if err != nil {
+		return v1alpha1.MegaConfigMapSpec{}, err
+	}
+	if manifest != nil {
+		return v1alpha1.SpecFromMaster(master, manifest), nil
+	}
+
+	id := master.Labels[combiner.IDLabel]
+	manifest = &combiner.Manifest{}
+	var owned []corev1.ConfigMap
+	for _, p := range partials {
+		if p.Labels[combiner.IDLabel] == id {
+			owned = append(owned, p)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		oi, _ := strconv.Atoi(owned[i].Labels[combiner.OrderLabel])
+		oj, _ := strconv.Atoi(owned[j].Labels[combiner.OrderLabel])
+		return oi < oj
+	})
+	for _, p := range owned {
+		data, _ := combiner.PartialData(&p)
+		size := int64(len(data))
+		manifest.Chunks = append(manifest.Chunks, combiner.Chunk{Name: p.Name, Bytes: size})
+		manifest.Bytes += size
+	}
+	return v1alpha1.SpecFromMaster(master, manifest), nil
+}
+
+func (r *Reconciler) ensureMegaConfigMap(master *corev1.ConfigMap, spec v1alpha1.MegaConfigMapSpec) (*v1alpha1.MegaConfigMap, error) {
+	client := r.dynamic.Resource(v1alpha1.Resource).Namespace(master.Namespace)
+	u, err := client.Get(master.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		u, err = v1alpha1.ToUnstructured(&v1alpha1.MegaConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: master.Namespace,
+				Name:      master.Name,
+				Labels:    map[string]string{combiner.IDLabel: spec.ID},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "ConfigMap",
+					Name:       master.Name,
+					UID:        master.UID,
+				}},
+			},
+			Spec: spec,
+		})
+		if err != nil {
+			return nil, err
+		}
+		u, err = client.Create(u, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return v1alpha1.FromUnstructured(u)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mcm, err := v1alpha1.FromUnstructured(u)
+	if err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(mcm.Spec, spec) && mcm.Labels[combiner.IDLabel] == spec.ID {
+		return mcm, nil
+	}
+	mcm.Spec = spec
+	if mcm.Labels == nil {
+		mcm.Labels = map[string]string{}
+	}
+	mcm.Labels[combiner.IDLabel] = spec.ID
+	u, err = v1alpha1.ToUnstructured(mcm)
+	if err != nil {
+		return nil, err
+	}
+	u, err = client.Update(u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return v1alpha1.FromUnstructured(u)
+}
+
+// syncPartials repairs owner references, deletes stale partials and counts ready chunks into status.
+// A partial is stale if its ID is neither the ID of master nor the ID being uploaded, and it is older than GracePeriod.
+func (r *Reconciler) syncPartials(master *corev1.ConfigMap, spec v1alpha1.MegaConfigMapSpec, partials []corev1.ConfigMap, status *v1alpha1.MegaConfigMapStatus) (time.Duration, error) {
+	configMaps := r.k8s.CoreV1().ConfigMaps(master.Namespace)
+	pendingID := master.Annotations[combiner.PendingAnnotation]
+	byName := make(map[string]*corev1.ConfigMap)
+	var graceRequeue time.Duration
+	for i := range partials {
+		p := &partials[i]
+		if id := p.Labels[combiner.IDLabel]; isLinkedTo(p, master) && id != spec.ID && id != pendingID {
+			if age := r.now().Sub(p.CreationTimestamp.Time); age < r.GracePeriod {
+				graceRequeue = shorter(graceRequeue, r.GracePeriod-age)
+				continue
+			}
+			// the resource version precondition keeps a partial that has just been replaced by its new version
+			err := configMaps.Delete(p.Name, &metav1.DeleteOptions{
+				Preconditions: &metav1.Preconditions{UID: &p.UID, ResourceVersion: &p.ResourceVersion},
+			})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return 0, err
+			}
+			continue
+		}
+		byName[p.Name] = p
+	}
+
+	var ready, missing, corrupted, repaired int
+	for _, chunk := range spec.Chunks {
+		p, ok := byName[chunk.Name]
+		if !ok || p.Labels[combiner.IDLabel] != spec.ID {
+			missing++
+			continue
+		}
+		data, _ := combiner.PartialData(p)
+		if len(chunk.SHA256) > 0 && fmt.Sprintf("%x", sha256.Sum256(data)) != chunk.SHA256 {
+			corrupted++
+			continue
+		}
+		if !isOwnedBy(p, master) {
+			p = p.DeepCopy()
+			p.OwnerReferences = append(p.OwnerReferences, metav1.OwnerReference{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Name:       master.Name,
+				UID:        master.UID,
+			})
+			_, err := configMaps.Update(p)
+			if err != nil {
+				return 0, err
+			}
+			repaired++
+		}
+		ready++
+	}
+
+	status.Chunks = len(spec.Chunks)
+	status.ReadyChunks = ready
+	status.TotalBytes = spec.Bytes
+	if repaired > 0 {
+		status.SetCondition(v1alpha1.ConditionOwnerReferencesValid, metav1.ConditionTrue, "Repaired",
+			fmt.Sprintf("added owner references to %d partial configmaps", repaired))
+	} else if status.Condition(v1alpha1.ConditionOwnerReferencesValid) == nil {
+		status.SetCondition(v1alpha1.ConditionOwnerReferencesValid, metav1.ConditionTrue, "Valid", "")
+	}
+
+	age := r.now().Sub(master.CreationTimestamp.Time)
+	switch {
+	case ready == len(spec.Chunks) && len(pendingID) == 0:
+		status.Phase = v1alpha1.PhaseReady
+		status.SetCondition(v1alpha1.ConditionChunksReady, metav1.ConditionTrue, "Ready",
+			fmt.Sprintf("%d/%d chunks are ready", ready, len(spec.Chunks)))
+		return graceRequeue, nil
+	case len(pendingID) > 0:
+		// kubectl megaconfigmap is uploading, or resumes the upload when it is run again
+		status.Phase = v1alpha1.PhasePending
+		status.SetCondition(v1alpha1.ConditionChunksReady, metav1.ConditionFalse, "Uploading",
+			fmt.Sprintf("%d/%d chunks are ready", ready, len(spec.Chunks)))
+		return graceRequeue, nil
+	case corrupted > 0:
+		status.Phase = v1alpha1.PhaseDegraded
+		status.SetCondition(v1alpha1.ConditionChunksReady, metav1.ConditionFalse, "Corrupted",
+			fmt.Sprintf("%d chunks are corrupted and %d chunks are missing", corrupted, missing))
+		return graceRequeue, nil
+	case age < r.PendingTimeout:
+		status.Phase = v1alpha1.PhasePending
+		status.SetCondition(v1alpha1.ConditionChunksReady, metav1.ConditionFalse, "Uploading",
+			fmt.Sprintf("%d/%d chunks are ready", ready, len(spec.Chunks)))
+		return shorter(graceRequeue, r.PendingTimeout-age), nil
+	default:
+		status.Phase = v1alpha1.PhaseDegraded
+		status.SetCondition(v1alpha1.ConditionChunksReady, metav1.ConditionFalse, "Missing",
+			fmt.Sprintf("%d chunks are missing", missing))
+		return graceRequeue, nil
+	}
+}
+
+// shorter returns the shorter positive duration of a and b, or zero if neither is positive
+func shorter(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (r *Reconciler) reportMasterNotFound(namespace, name string) error {
+	client := r.dynamic.Resource(v1alpha1.Resource).Namespace(namespace)
+	u, err := client.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mcm, err := v1alpha1.FromUnstructured(u)
+	if err != nil {
+		return err
+	}
+	status := mcm.Status.DeepCopy()
+	status.Phase = v1alpha1.PhaseDegraded
+	status.ObservedGeneration = mcm.Generation
+	status.ReadyChunks = 0
+	status.SetCondition(v1alpha1.ConditionMasterFound, metav1.ConditionFalse, "NotFound",
+		fmt.Sprintf("configmap %s is not found", name))
+	return r.updateStatus(mcm, status)
+}
+
+func (r *Reconciler) updateStatus(mcm *v1alpha1.MegaConfigMap, status *v1alpha1.MegaConfigMapStatus) error {
+	if reflect.DeepEqual(&mcm.Status, status) {
+		return nil
+	}
+	mcm = mcm.DeepCopy()
+	mcm.Status = *status
+	u, err := v1alpha1.ToUnstructured(mcm)
+	if err != nil {
+		return err
+	}
+	_, err = r.dynamic.Resource(v1alpha1.Resource).Namespace(mcm.Namespace).UpdateStatus(u, metav1.UpdateOptions{})
+	return err
+}
+
+// isLinkedTo returns true if obj is owned by master or labeled with its name
+func isLinkedTo(obj *corev1.ConfigMap, master *corev1.ConfigMap) bool {
+	return isOwnedBy(obj, master) || obj.Labels[combiner.NameLabel] == master.Name
+}
+
+func isOwnedBy(obj *corev1.ConfigMap, owner *corev1.ConfigMap) bool {
+	for _, ref := range obj.OwnerReferences {
+		if ref.UID == owner.UID {
+			return true
+		}
+	}
+	return false
+}
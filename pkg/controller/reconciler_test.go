@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/apis/v1alpha1"
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testNamespace = "default"
+
+func newMaster(data []byte, blockBytes int64) *corev1.ConfigMap {
+	manifest, err := combiner.NewManifest(data, "my-conf", blockBytes).Marshal()
+	if err != nil {
+		panic(err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         testNamespace,
+			Name:              "my-conf",
+			UID:               types.UID("master-uid"),
+			CreationTimestamp: metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)),
+			Labels: map[string]string{
+				combiner.IDLabel:       combiner.MapID(data, testNamespace, "my-conf"),
+				combiner.FileNameLabel: "data",
+				combiner.MasterLabel:   "true",
+			},
+		},
+		Data: map[string]string{combiner.ManifestKey: manifest},
+	}
+}
+
+func newPartial(master *corev1.ConfigMap, id string, order int64, data string, owned bool) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      combiner.PartialName(master.Name, order),
+			UID:       types.UID(combiner.PartialName(master.Name, order) + "-" + id),
+			Labels: map[string]string{
+				combiner.IDLabel:    id,
+				combiner.OrderLabel: "0",
+			},
+		},
+		Data: map[string]string{combiner.PartialItemKey: data},
+	}
+	if owned {
+		cm.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: master.Name, UID: master.UID}}
+	}
+	return cm
+}
+
+// newTestReconciler returns a Reconciler and a function syncing its cache with k8s, as the informer of the Controller does
+func newTestReconciler(t *testing.T, k8s *fake.Clientset, dynamicClient *dynamicfake.FakeDynamicClient) (*Reconciler, func()) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, configMapIndexers)
+	sync := func() {
+		cms, err := k8s.CoreV1().ConfigMaps(testNamespace).List(metav1.ListOptions{LabelSelector: combiner.IDLabel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var objs []interface{}
+		for i := range cms.Items {
+			objs = append(objs, &cms.Items[i])
+		}
+		if err := indexer.Replace(objs, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sync()
+	return NewReconciler(k8s, dynamicClient, indexer), sync
+}
+
+func linked(cm *corev1.ConfigMap) *corev1.ConfigMap {
+	cm.Labels[combiner.NameLabel] = "my-conf"
+	return cm
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	master := newMaster([]byte("abcde"), 3)
+	id := master.Labels[combiner.IDLabel]
+	pendingMaster := master.DeepCopy()
+	pendingMaster.Annotations = map[string]string{combiner.PendingAnnotation: "new"}
+	recent := newPartial(master, "new", 2, "xyz", true)
+	recent.CreationTimestamp = metav1.Now()
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		wantPhase   v1alpha1.Phase
+		wantReady   int
+		wantOwned   []string
+		wantAbsent  []string
+		wantPresent []string
+	}{
+		{
+			name: "ready",
+			objects: []runtime.Object{
+				master,
+				newPartial(master, id, 0, "abc", true),
+				newPartial(master, id, 1, "de", true),
+			},
+			wantPhase: v1alpha1.PhaseReady,
+			wantReady: 2,
+			wantOwned: []string{"my-conf-0", "my-conf-1"},
+		},
+		{
+			name: "repair owner references",
+			objects: []runtime.Object{
+				master,
+				newPartial(master, id, 0, "abc", false),
+				newPartial(master, id, 1, "de", true),
+			},
+			wantPhase: v1alpha1.PhaseReady,
+			wantReady: 2,
+			wantOwned: []string{"my-conf-0", "my-conf-1"},
+		},
+		{
+			name: "corrupted",
+			objects: []runtime.Object{
+				master,
+				newPartial(master, id, 0, "abc", true),
+				newPartial(master, id, 1, "DE", true),
+			},
+			wantPhase: v1alpha1.PhaseDegraded,
+			wantReady: 1,
+		},
+		{
+			name: "missing",
+			objects: []runtime.Object{
+				master,
+				newPartial(master, id, 0, "abc", true),
+			},
+			wantPhase: v1alpha1.PhaseDegraded,
+			wantReady: 1,
+		},
+		{
+			name: "partials linked by the label",
+			objects: []runtime.Object{
+				master,
+				linked(newPartial(master, id, 0, "abc", false)),
+				linked(newPartial(master, id, 1, "de", false)),
+				linked(newPartial(master, "old", 2, "xyz", false)),
+			},
+			wantPhase:  v1alpha1.PhaseReady,
+			wantReady:  2,
+			wantOwned:  []string{"my-conf-0", "my-conf-1"},
+			wantAbsent: []string{"my-conf-2"},
+		},
+		{
+			name: "stale version is deleted",
+			objects: []runtime.Object{
+				master,
+				newPartial(master, id, 0, "abc", true),
+				newPartial(master, id, 1, "de", true),
+				newPartial(master, "old", 2, "xyz", true),
+			},
+			wantPhase:  v1alpha1.PhaseReady,
+			wantReady:  2,
+			wantAbsent: []string{"my-conf-2"},
+		},
+		{
+			name: "other version within the grace period is kept",
+			objects: []runtime.Object{
+				master,
+				newPartial(master, id, 0, "abc", true),
+				newPartial(master, id, 1, "de", true),
+				recent,
+			},
+			wantPhase:   v1alpha1.PhaseReady,
+			wantReady:   2,
+			wantPresent: []string{"my-conf-2"},
+		},
+		{
+			name: "version being uploaded is kept",
+			objects: []runtime.Object{
+				pendingMaster,
+				newPartial(master, id, 0, "abc", true),
+				newPartial(master, id, 1, "de", true),
+				newPartial(master, "new", 2, "xyz", true),
+			},
+			wantPhase:   v1alpha1.PhasePending,
+			wantReady:   2,
+			wantPresent: []string{"my-conf-2"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			k8s := fake.NewSimpleClientset(tt.objects...)
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+			r, _ := newTestReconciler(t, k8s, dynamicClient)
+			_, err := r.Reconcile(testNamespace, "my-conf")
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			u, err := dynamicClient.Resource(v1alpha1.Resource).Namespace(testNamespace).Get("my-conf", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get MegaConfigMap: %v", err)
+			}
+			mcm, err := v1alpha1.FromUnstructured(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mcm.Spec.ID != id || len(mcm.Spec.Chunks) != 2 || mcm.Spec.Bytes != 5 {
+				t.Errorf("unexpected spec: %+v", mcm.Spec)
+			}
+			if mcm.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %v, want %v", mcm.Status.Phase, tt.wantPhase)
+			}
+			if mcm.Status.ReadyChunks != tt.wantReady {
+				t.Errorf("readyChunks = %v, want %v", mcm.Status.ReadyChunks, tt.wantReady)
+			}
+			for _, name := range tt.wantOwned {
+				cm, err := k8s.CoreV1().ConfigMaps(testNamespace).Get(name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !isOwnedBy(cm, master) {
+					t.Errorf("%s is not owned by the master", name)
+				}
+			}
+			for _, name := range tt.wantAbsent {
+				_, err := k8s.CoreV1().ConfigMaps(testNamespace).Get(name, metav1.GetOptions{})
+				if err == nil {
+					t.Errorf("%s is not deleted", name)
+				}
+			}
+			for _, name := range tt.wantPresent {
+				if _, err := k8s.CoreV1().ConfigMaps(testNamespace).Get(name, metav1.GetOptions{}); err != nil {
+					t.Errorf("%s is deleted: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReconciler_Reconcile_pending(t *testing.T) {
+	master := newMaster([]byte("abcde"), 3)
+	k8s := fake.NewSimpleClientset(master)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	r, _ := newTestReconciler(t, k8s, dynamicClient)
+	r.now = func() time.Time { return master.CreationTimestamp.Add(time.Minute) }
+
+	requeue, err := r.Reconcile(testNamespace, "my-conf")
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if requeue != r.PendingTimeout-time.Minute {
+		t.Errorf("requeue = %v, want %v", requeue, r.PendingTimeout-time.Minute)
+	}
+	u, err := dynamicClient.Resource(v1alpha1.Resource).Namespace(testNamespace).Get("my-conf", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcm, err := v1alpha1.FromUnstructured(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mcm.Status.Phase != v1alpha1.PhasePending {
+		t.Errorf("phase = %v, want %v", mcm.Status.Phase, v1alpha1.PhasePending)
+	}
+}
+
+func TestReconciler_Reconcile_duringApply(t *testing.T) {
+	ctx := context.Background()
+	k8s := fake.NewSimpleClientset()
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	r, sync := newTestReconciler(t, k8s, dynamicClient)
+	reconcile := func() {
+		sync()
+		if _, err := r.Reconcile(testNamespace, "my-conf"); err != nil {
+			t.Errorf("Reconcile() error = %v", err)
+		}
+	}
+	// reconcile each time a partial configmap is uploaded
+	c := client.New(k8s, client.WithChunkSize(3), client.WithParallelism(1), client.WithProgress(func(client.Progress) { reconcile() }))
+	if _, err := c.Create(ctx, testNamespace, "my-conf", "data", []byte("abcdefghi")); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"0123456789ab", "xyz"} {
+		if _, _, err := c.Apply(ctx, testNamespace, "my-conf", "data", []byte(data)); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		if err := c.Verify(ctx, testNamespace, "my-conf"); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		reconcile()
+		u, err := dynamicClient.Resource(v1alpha1.Resource).Namespace(testNamespace).Get("my-conf", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mcm, err := v1alpha1.FromUnstructured(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mcm.Status.Phase != v1alpha1.PhaseReady {
+			t.Errorf("phase = %v, want %v", mcm.Status.Phase, v1alpha1.PhaseReady)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package csi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dulltz/megaconfigmap/pkg/cache"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DriverName is the name of the CSI driver
+	DriverName = "csi.megaconfigmap.io"
+	// MegaConfigMapAttribute is the volume attribute to specify the megaconfigmap
+	MegaConfigMapAttribute = "megaconfigmap"
+
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	ephemeralKey    = "csi.storage.k8s.io/ephemeral"
+
+	// volumesDir is the directory in the cache directory recording the version published by each volume
+	volumesDir = ".volumes"
+)
+
+// Driver is a CSI node plugin that materializes megaconfigmaps as read-only inline ephemeral volumes.
+// Combined files are cached in a cache.Store by their megaconfigmap ID, so pods on the node share one copy.
+// Versions are removed from the cache when no volume publishes them any more.
+type Driver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedNodeServer
+
+	store      *cache.Store
+	volumesDir string
+	nodeID     string
+	version    string
+	mounter    Mounter
+
+	// mu is held for reading while a volume is published, and for writing while unused versions are removed
+	mu sync.RWMutex
+}
+
+// NewDriver creates a Driver
+func NewDriver(k8s kubernetes.Interface, nodeID, version, cacheDir string, mounter Mounter) *Driver {
+	return &Driver{
+		store:      cache.NewStore(k8s, cacheDir),
+		volumesDir: filepath.Join(cacheDir, volumesDir),
+		nodeID:     nodeID,
+		version:    version,
+		mounter:    mounter,
+	}
+}
+
+// GetPluginInfo implements csi.IdentityServer
+func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: DriverName, VendorVersion: d.version}, nil
+}
+
+// GetPluginCapabilities implements csi.IdentityServer. The driver has no controller service.
+func (d *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{}, nil
+}
+
+// Probe implements csi.IdentityServer
+func (d *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+// NodeGetInfo implements csi.NodeServer
+func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: d.nodeID}, nil
+}
+
+// NodeGetCapabilities implements csi.NodeServer
+func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+// NodePublishVolume assembles the megaconfigmap in volumeAttributes and bind-mounts it read-only to the target path
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if len(req.GetVolumeId()) == 0 || len(req.GetTargetPath()) == 0 || req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume ID, target path and volume capability are required")
+	}
+	attrs := req.GetVolumeContext()
+	if attrs[ephemeralKey] != "true" {
+		return nil, status.Error(codes.InvalidArgument, "only inline ephemeral volumes are supported")
+	}
+	name := attrs[MegaConfigMapAttribute]
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volumeAttributes."+MegaConfigMapAttribute+" is required")
+	}
+	namespace := attrs[podNamespaceKey]
+	if len(namespace) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "pod namespace is not given; set podInfoOnMount of the CSIDriver")
+	}
+
+	target := req.GetTargetPath()
+	mounted, err := d.mounter.IsMountPoint(target)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	entry, err := d.store.Assemble(namespace, name)
+	if err != nil {
+		return nil, status.Error(errorCode(err), err.Error())
+	}
+	err = d.recordVolume(req.GetVolumeId(), entry.ID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = os.MkdirAll(target, 0750)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = d.mounter.BindMount(entry.Dir, target, true)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume unmounts and removes the target path
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if len(req.GetVolumeId()) == 0 || len(req.GetTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume ID and target path are required")
+	}
+	target := req.GetTargetPath()
+	mounted, err := d.mounter.IsMountPoint(target)
+	if os.IsNotExist(err) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		if err := d.mounter.Unmount(target); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = os.Remove(d.volumePath(req.GetVolumeId()))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := d.removeUnused(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// recordVolume records that volumeID publishes the version id, so that it is not removed from the cache.
+// Records are files so that they survive restarts of the driver.
+func (d *Driver) recordVolume(volumeID, id string) error {
+	err := os.MkdirAll(d.volumesDir, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.volumePath(volumeID), []byte(id), 0644)
+}
+
+func (d *Driver) volumePath(volumeID string) string {
+	return filepath.Join(d.volumesDir, url.PathEscape(volumeID))
+}
+
+// removeUnused removes the versions that no volume publishes from the cache
+func (d *Driver) removeUnused() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	infos, err := ioutil.ReadDir(d.volumesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	published := make(map[string]bool)
+	for _, info := range infos {
+		id, err := ioutil.ReadFile(filepath.Join(d.volumesDir, info.Name()))
+		if err != nil {
+			return err
+		}
+		published[string(id)] = true
+	}
+	return d.store.Remove(func(id string) bool { return published[id] })
+}
+
+func errorCode(err error) codes.Code {
+	switch combiner.ReasonOf(err) {
+	case combiner.ReasonNotFound:
+		return codes.NotFound
+	case combiner.ReasonForbidden:
+		return codes.PermissionDenied
+	case combiner.ReasonInvalidConfigMap, combiner.ReasonChecksumMismatch:
+		return codes.FailedPrecondition
+	case combiner.ReasonInsufficientStorage:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
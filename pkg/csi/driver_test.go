@@ -0,0 +1,186 @@
+package csi
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeMounter struct {
+	mounts map[string]string
+}
+
+func (m *fakeMounter) BindMount(source, target string, readOnly bool) error {
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsMountPoint(target string) (bool, error) {
+	if _, err := os.Stat(target); err != nil {
+		return false, err
+	}
+	_, ok := m.mounts[target]
+	return ok, nil
+}
+
+func TestDriver_NodePublishVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap-csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := megaconfigmaptest.New(t)
+	cluster.Put(megaconfigmaptest.ConfigMaps("default", "my-conf", []byte("abcdefg"), 3)...)
+	k8s := cluster.Client()
+	mounter := &fakeMounter{mounts: map[string]string{}}
+	d := NewDriver(k8s, "node1", "test", cacheDir, mounter)
+	publish := func(volumeID string, attrs map[string]string) error {
+		_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:         volumeID,
+			TargetPath:       filepath.Join(dir, volumeID),
+			VolumeCapability: &csi.VolumeCapability{},
+			Readonly:         true,
+			VolumeContext:    attrs,
+		})
+		return err
+	}
+	attrs := map[string]string{MegaConfigMapAttribute: "my-conf", podNamespaceKey: "default", ephemeralKey: "true"}
+
+	if err := publish("vol1", attrs); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if err := publish("vol2", attrs); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if err := publish("vol2", attrs); err != nil {
+		t.Fatalf("NodePublishVolume() is not idempotent: %v", err)
+	}
+	source := mounter.mounts[filepath.Join(dir, "vol1")]
+	if source == "" || source != mounter.mounts[filepath.Join(dir, "vol2")] {
+		t.Errorf("volumes should share one cache: %v", mounter.mounts)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(source, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcdefg" {
+		t.Errorf("combined data = %s", data)
+	}
+	for path, want := range map[string]os.FileMode{source: 0755, filepath.Join(source, "data"): 0444} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %v, want %v, so that non-root pods can read it", path, info.Mode().Perm(), want)
+		}
+	}
+
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol1", TargetPath: filepath.Join(dir, "vol1")})
+	if err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	if _, ok := mounter.mounts[filepath.Join(dir, "vol1")]; ok {
+		t.Error("vol1 is still mounted")
+	}
+
+	err = publish("vol3", map[string]string{MegaConfigMapAttribute: "missing", podNamespaceKey: "default", ephemeralKey: "true"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("NodePublishVolume() error = %v, want NotFound", err)
+	}
+	err = publish("vol4", map[string]string{MegaConfigMapAttribute: "my-conf", podNamespaceKey: "default"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodePublishVolume() error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestDriver_NodeUnpublishVolume_removeUnused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megaconfigmap-csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := megaconfigmaptest.New(t)
+	k8s := cluster.Client()
+	put := func(data string) {
+		cluster.Put(megaconfigmaptest.ConfigMaps("default", "my-conf", []byte(data), 3)...)
+	}
+	mounter := &fakeMounter{mounts: map[string]string{}}
+	d := NewDriver(k8s, "node1", "test", cacheDir, mounter)
+	attrs := map[string]string{MegaConfigMapAttribute: "my-conf", podNamespaceKey: "default", ephemeralKey: "true"}
+	publish := func(volumeID string) string {
+		target := filepath.Join(dir, volumeID)
+		_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:         volumeID,
+			TargetPath:       target,
+			VolumeCapability: &csi.VolumeCapability{},
+			Readonly:         true,
+			VolumeContext:    attrs,
+		})
+		if err != nil {
+			t.Fatalf("NodePublishVolume() error = %v", err)
+		}
+		return mounter.mounts[target]
+	}
+	unpublish := func(volumeID string) {
+		_, err := d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: filepath.Join(dir, volumeID)})
+		if err != nil {
+			t.Fatalf("NodeUnpublishVolume() error = %v", err)
+		}
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	put("abcdefg")
+	v1 := publish("vol1")
+	publish("vol2")
+	put("gfedcba")
+	v2 := publish("vol3")
+	if v1 == v2 {
+		t.Fatalf("versions share %s", v1)
+	}
+
+	unpublish("vol1")
+	if !exists(v1) {
+		t.Error("version published by vol2 is removed")
+	}
+	unpublish("vol2")
+	if exists(v1) {
+		t.Error("version published by no volume is kept")
+	}
+
+	// records of published volumes survive restarts
+	d = NewDriver(k8s, "node1", "test", cacheDir, mounter)
+	unpublish("vol4")
+	if !exists(v2) {
+		t.Error("version published by vol3 is removed after restart")
+	}
+	unpublish("vol3")
+	if exists(v2) {
+		t.Error("version published by no volume is kept after restart")
+	}
+}
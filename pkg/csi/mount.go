@@ -0,0 +1,11 @@
+package csi
+
+// Mounter mounts directories. It is an interface so that the driver can be tested without privileges.
+type Mounter interface {
+	// BindMount bind-mounts source to target
+	BindMount(source, target string, readOnly bool) error
+	// Unmount unmounts target
+	Unmount(target string) error
+	// IsMountPoint returns true if target is a mount point
+	IsMountPoint(target string) (bool, error)
+}
@@ -0,0 +1,82 @@
+package csi
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// NewMounter returns a Mounter using mount(2)
+func NewMounter() Mounter {
+	return linuxMounter{}
+}
+
+type linuxMounter struct{}
+
+func (linuxMounter) BindMount(source, target string, readOnly bool) error {
+	err := syscall.Mount(source, target, "", syscall.MS_BIND, "")
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return nil
+	}
+	// MS_RDONLY is ignored on the initial bind mount, so remount it
+	err = syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+	if err != nil {
+		syscall.Unmount(target, 0)
+	}
+	return err
+}
+
+func (linuxMounter) Unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
+
+func (linuxMounter) IsMountPoint(target string) (bool, error) {
+	if _, err := os.Stat(target); err != nil {
+		return false, err
+	}
+	target, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if unescapeMountPath(fields[4]) == target {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// unescapeMountPath decodes octal escapes like \040 in /proc/self/mountinfo
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package csi
+
+import "errors"
+
+// NewMounter returns a Mounter that always fails because bind mounts are supported only on Linux
+func NewMounter() Mounter {
+	return unsupportedMounter{}
+}
+
+type unsupportedMounter struct{}
+
+var errUnsupported = errors.New("bind mount is not supported on this platform")
+
+func (unsupportedMounter) BindMount(source, target string, readOnly bool) error {
+	return errUnsupported
+}
+
+func (unsupportedMounter) Unmount(target string) error {
+	return errUnsupported
+}
+
+func (unsupportedMounter) IsMountPoint(target string) (bool, error) {
+	return false, errUnsupported
+}
@@ -0,0 +1,395 @@
+// Package doctor runs preflight checks finding why megaconfigmaps cannot be created or combined.
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Status is the result of a check
+type Status string
+
+const (
+	// Pass means nothing needs to be done
+	Pass Status = "PASS"
+	// Warn means something may not work, or could not be checked
+	Warn Status = "WARN"
+	// Fail means megaconfigmaps cannot be created or combined until it is fixed
+	Fail Status = "FAIL"
+)
+
+// ProbeName is the name of the configmap created with dry-run to probe the request size
+const ProbeName = "megaconfigmap-doctor-probe"
+
+var (
+	configmaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	// callerVerbs are the verbs on configmaps used by create, update and delete
+	callerVerbs = []string{"create", "get", "list", "update", "delete"}
+)
+
+// Finding is the result of a check with what to do about it
+type Finding struct {
+	Check   string
+	Status  Status
+	Message string
+}
+
+// Options configures Run
+type Options struct {
+	// Namespace is the namespace to check
+	Namespace string
+	// MegaConfigMaps are the names of the megaconfigmaps to check. Empty means all in Namespace.
+	MegaConfigMaps []string
+	// ServiceAccount is the service account of combiners. Empty means it is not checked.
+	ServiceAccount string
+	// BlockBytes is the size of partial configmaps to probe
+	BlockBytes int64
+	// Client is the clientset of the caller
+	Client kubernetes.Interface
+	// Dynamic creates the probe with dry-run, which the clientset does not support
+	Dynamic dynamic.Interface
+	// Metadata lists partial configmaps without their data. The default is the REST client of Client.
+	Metadata rest.Interface
+	// VerifyContent downloads every partial configmap and verifies the content against the manifest.
+	// By default only the manifest and the metadata of partial configmaps are checked.
+	VerifyContent bool
+}
+
+// Run runs all checks and returns their findings in order
+func Run(ctx context.Context, opts *Options) []Finding {
+	var findings []Finding
+	findings = append(findings, checkCaller(opts)...)
+	mcms, finding := listMegaConfigMaps(ctx, opts)
+	if finding != nil {
+		findings = append(findings, *finding)
+	}
+	if len(opts.ServiceAccount) > 0 {
+		findings = append(findings, checkServiceAccount(opts, mcms)...)
+	}
+	findings = append(findings, checkQuota(opts, mcms)...)
+	findings = append(findings, checkRequestSize(opts))
+	findings = append(findings, checkHealth(ctx, opts, mcms)...)
+	return findings
+}
+
+// Print writes findings to w, one per line
+func Print(w io.Writer, findings []Finding) error {
+	for _, f := range findings {
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", f.Status, f.Check, f.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Failed returns the number of failed findings
+func Failed(findings []Finding) int {
+	var n int
+	for _, f := range findings {
+		if f.Status == Fail {
+			n++
+		}
+	}
+	return n
+}
+
+func checkCaller(opts *Options) []Finding {
+	const check = "caller access"
+	var denied []string
+	for _, verb := range callerVerbs {
+		allowed, err := selfAccess(opts, verb, "configmaps")
+		if err != nil {
+			return []Finding{{check, Warn, fmt.Sprintf("failed to review access: %v", err)}}
+		}
+		if !allowed {
+			denied = append(denied, verb)
+		}
+	}
+	var findings []Finding
+	if len(denied) > 0 {
+		findings = append(findings, Finding{check, Fail, fmt.Sprintf("you cannot %s configmaps in %s; ask an administrator for a Role allowing %s",
+			strings.Join(denied, ", "), opts.Namespace, strings.Join(callerVerbs, ", "))})
+	} else {
+		findings = append(findings, Finding{check, Pass, fmt.Sprintf("you can %s configmaps in %s", strings.Join(callerVerbs, ", "), opts.Namespace)})
+	}
+	allowed, err := selfAccess(opts, "create", "events")
+	if err == nil && !allowed {
+		findings = append(findings, Finding{check, Warn, fmt.Sprintf("you cannot create events in %s, so updates and deletions are not recorded", opts.Namespace)})
+	}
+	return findings
+}
+
+func selfAccess(opts *Options, verb, resource string) (bool, error) {
+	review, err := opts.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: opts.Namespace, Verb: verb, Resource: resource},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+func listMegaConfigMaps(ctx context.Context, opts *Options) ([]*client.MegaConfigMap, *Finding) {
+	c := client.New(opts.Client)
+	if len(opts.MegaConfigMaps) == 0 {
+		mcms, err := c.List(ctx, opts.Namespace)
+		if err != nil {
+			return nil, &Finding{"megaconfigmaps", Warn, fmt.Sprintf("failed to list megaconfigmaps, so they are not checked: %v", err)}
+		}
+		return mcms, nil
+	}
+	var mcms []*client.MegaConfigMap
+	for _, name := range opts.MegaConfigMaps {
+		mcm, err := c.Get(ctx, opts.Namespace, name)
+		if err != nil {
+			return mcms, &Finding{"megaconfigmaps", Fail, fmt.Sprintf("failed to get megaconfigmap %s: %v", name, err)}
+		}
+		mcms = append(mcms, mcm)
+	}
+	return mcms, nil
+}
+
+func checkServiceAccount(opts *Options, mcms []*client.MegaConfigMap) []Finding {
+	check := "service account " + opts.ServiceAccount
+	user := fmt.Sprintf("system:serviceaccount:%s:%s", opts.Namespace, opts.ServiceAccount)
+	access := func(verb, resource, name string) (bool, error) {
+		review, err := opts.Client.AuthorizationV1().LocalSubjectAccessReviews(opts.Namespace).Create(&authorizationv1.LocalSubjectAccessReview{
+			ObjectMeta: metav1.ObjectMeta{Namespace: opts.Namespace},
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               user,
+				Groups:             []string{"system:serviceaccounts", "system:serviceaccounts:" + opts.Namespace, "system:authenticated"},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: opts.Namespace, Verb: verb, Resource: resource, Name: name},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		return review.Status.Allowed, nil
+	}
+
+	var findings []Finding
+	if len(mcms) == 0 {
+		allowed, err := access("get", "configmaps", "")
+		switch {
+		case err != nil:
+			return []Finding{{check, Warn, fmt.Sprintf("failed to review access: %v", err)}}
+		case !allowed:
+			findings = append(findings, Finding{check, Warn, fmt.Sprintf("%s cannot get configmaps in %s; bind it to the Role printed by rbac for each megaconfigmap", opts.ServiceAccount, opts.Namespace)})
+		default:
+			findings = append(findings, Finding{check, Pass, fmt.Sprintf("%s can get configmaps in %s", opts.ServiceAccount, opts.Namespace)})
+		}
+	}
+	for _, mcm := range mcms {
+		names := []string{mcm.Name}
+		if mcm.Manifest != nil {
+			for _, chunk := range mcm.Manifest.Chunks {
+				names = append(names, chunk.Name)
+			}
+		}
+		var denied string
+		for _, name := range names {
+			allowed, err := access("get", "configmaps", name)
+			if err != nil {
+				return append(findings, Finding{check, Warn, fmt.Sprintf("failed to review access: %v", err)})
+			}
+			if !allowed {
+				denied = name
+				break
+			}
+		}
+		if mcm.Manifest == nil && len(denied) == 0 {
+			if allowed, err := access("list", "configmaps", ""); err == nil && !allowed {
+				denied = "the list of configmaps"
+			}
+		}
+		if len(denied) > 0 {
+			findings = append(findings, Finding{check, Fail, fmt.Sprintf("%s cannot get %s of megaconfigmap %s; run `kubectl megaconfigmap rbac %s --serviceaccount %s` and apply it",
+				opts.ServiceAccount, denied, mcm.Name, mcm.Name, opts.ServiceAccount)})
+			continue
+		}
+		findings = append(findings, Finding{check, Pass, fmt.Sprintf("%s can read megaconfigmap %s", opts.ServiceAccount, mcm.Name)})
+	}
+	if allowed, err := access("create", "events", ""); err == nil && !allowed {
+		findings = append(findings, Finding{check, Warn, fmt.Sprintf("%s cannot create events in %s, so combine failures are not recorded on pods", opts.ServiceAccount, opts.Namespace)})
+	}
+	return findings
+}
+
+func checkQuota(opts *Options, mcms []*client.MegaConfigMap) []Finding {
+	const check = "quota"
+	quotas, err := opts.Client.CoreV1().ResourceQuotas(opts.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return []Finding{{check, Warn, fmt.Sprintf("failed to list resource quotas: %v", err)}}
+	}
+	// a new megaconfigmap as large as the largest one needs its partial configmaps and the master
+	var needed int64 = 1
+	for _, mcm := range mcms {
+		if mcm.Manifest != nil && int64(len(mcm.Manifest.Chunks))+1 > needed {
+			needed = int64(len(mcm.Manifest.Chunks)) + 1
+		}
+	}
+
+	var findings []Finding
+	for _, quota := range quotas.Items {
+		for _, resource := range []corev1.ResourceName{"count/configmaps", corev1.ResourceConfigMaps} {
+			hard, ok := quota.Status.Hard[resource]
+			if !ok {
+				hard, ok = quota.Spec.Hard[resource]
+			}
+			if !ok {
+				continue
+			}
+			used := quota.Status.Used[resource]
+			headroom := hard.Value() - used.Value()
+			message := fmt.Sprintf("resource quota %s allows %d more configmaps (%s of %s used)", quota.Name, headroom, used.String(), hard.String())
+			switch {
+			case headroom <= 0:
+				findings = append(findings, Finding{check, Fail, message + "; delete unused configmaps or raise " + string(resource)})
+			case headroom < needed:
+				findings = append(findings, Finding{check, Warn, fmt.Sprintf("%s, but the largest megaconfigmap needs %d; raise %s or --block-bytes", message, needed, resource)})
+			default:
+				findings = append(findings, Finding{check, Pass, message})
+			}
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{check, Pass, fmt.Sprintf("no resource quota limits configmaps in %s", opts.Namespace)})
+	}
+	return findings
+}
+
+// checkRequestSize creates a partial configmap of BlockBytes with dry-run.
+// The data is not valid UTF-8, so it is stored in binaryData as the largest partial configmaps are.
+func checkRequestSize(opts *Options) Finding {
+	const check = "request size"
+	probe := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: opts.Namespace, Name: ProbeName},
+		BinaryData: map[string][]byte{combiner.PartialItemKey: bytes.Repeat([]byte{0xff}, int(opts.BlockBytes))},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(probe)
+	if err != nil {
+		return Finding{check, Fail, err.Error()}
+	}
+	_, err = opts.Dynamic.Resource(configmaps).Namespace(opts.Namespace).Create(&unstructured.Unstructured{Object: obj},
+		metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	var status apierrors.APIStatus
+	switch {
+	case err == nil || apierrors.IsAlreadyExists(err):
+		return Finding{check, Pass, fmt.Sprintf("the API server accepts partial configmaps of --block-bytes=%d", opts.BlockBytes)}
+	case apierrors.IsForbidden(err):
+		return Finding{check, Warn, fmt.Sprintf("failed to probe --block-bytes=%d: %v", opts.BlockBytes, err)}
+	case apierrors.IsRequestEntityTooLargeError(err), isTooLong(err):
+		return Finding{check, Fail, fmt.Sprintf("the API server rejects partial configmaps of --block-bytes=%d: %v; lower --block-bytes", opts.BlockBytes, err)}
+	case errors.As(err, &status):
+		s := status.Status()
+		return Finding{check, Fail, fmt.Sprintf("the API server rejects partial configmaps of --block-bytes=%d with %d %s: %v", opts.BlockBytes, s.Code, s.Reason, err)}
+	}
+	return Finding{check, Warn, fmt.Sprintf("failed to probe --block-bytes=%d: %v", opts.BlockBytes, err)}
+}
+
+// isTooLong returns true if err is an Invalid error caused by the size of a field
+func isTooLong(err error) bool {
+	if !apierrors.IsInvalid(err) {
+		return false
+	}
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return false
+	}
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseType(field.ErrorTypeTooLong) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkHealth(ctx context.Context, opts *Options, mcms []*client.MegaConfigMap) []Finding {
+	c := client.New(opts.Client)
+	var findings []Finding
+	for _, mcm := range mcms {
+		check := "megaconfigmap " + mcm.Name
+		if mcm.Pending {
+			findings = append(findings, Finding{check, Fail, fmt.Sprintf("its upload is not complete; run `kubectl megaconfigmap create %s --from-file` again to resume it, or delete it", mcm.Name)})
+			continue
+		}
+		var err error
+		if opts.VerifyContent {
+			err = c.Verify(ctx, opts.Namespace, mcm.Name)
+		} else if mcm.Manifest != nil {
+			err = checkPartials(opts, mcm)
+		}
+		if err != nil {
+			findings = append(findings, Finding{check, Fail, fmt.Sprintf("%v; recreate it with `kubectl megaconfigmap update %s --from-file`", err, mcm.Name)})
+			continue
+		}
+		if mcm.Manifest == nil {
+			findings = append(findings, Finding{check, Warn, "it has no manifest, so combiners need to list configmaps; update it to add the manifest"})
+			continue
+		}
+		if opts.VerifyContent {
+			findings = append(findings, Finding{check, Pass, fmt.Sprintf("%d partial configmaps are intact", len(mcm.Manifest.Chunks))})
+		} else {
+			findings = append(findings, Finding{check, Pass, fmt.Sprintf("%d partial configmaps exist; add --verify-content to verify their content", len(mcm.Manifest.Chunks))})
+		}
+	}
+	return findings
+}
+
+// checkPartials checks that every partial configmap in the manifest of mcm exists with the ID and order of mcm.
+// Only their metadata is fetched.
+func checkPartials(opts *Options, mcm *client.MegaConfigMap) error {
+	metadata := opts.Metadata
+	if metadata == nil {
+		metadata = opts.Client.CoreV1().RESTClient()
+	}
+	data, err := metadata.Get().Namespace(opts.Namespace).Resource("configmaps").
+		Param("labelSelector", fmt.Sprintf("%s=%s,%s!=true", combiner.IDLabel, mcm.ID, combiner.MasterLabel)).
+		SetHeader("Accept", "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1").
+		DoRaw()
+	if err != nil {
+		return err
+	}
+	var list metav1.PartialObjectMetadataList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	orders := make(map[string]string)
+	for _, item := range list.Items {
+		orders[item.Name] = item.Labels[combiner.OrderLabel]
+	}
+	var missing []string
+	for i, chunk := range mcm.Manifest.Chunks {
+		if order, ok := orders[chunk.Name]; !ok || order != strconv.Itoa(i) {
+			missing = append(missing, chunk.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("partial configmaps %s are missing", strings.Join(missing, ", "))
+	}
+	return nil
+}
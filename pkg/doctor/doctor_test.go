@@ -0,0 +1,235 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRun(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "configmaps"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{"count/configmaps": resource.MustParse("10")},
+			Used: corev1.ResourceList{"count/configmaps": resource.MustParse("8")},
+		},
+	}
+	c := megaconfigmaptest.New(t, quota)
+	defer c.Cleanup()
+	c.Create("default", "good", "conf.txt", []byte("0123456789"), client.WithChunkSize(4))
+	c.Create("default", "broken", "conf.txt", []byte("0123456789"), client.WithChunkSize(4))
+	c.CorruptChunk("default", "broken", 1)
+	c.Create("default", "missing", "conf.txt", []byte("0123456789"), client.WithChunkSize(4))
+	c.DeleteChunk("default", "missing", 2)
+
+	c.Fake().PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb != "delete"
+		return true, review, nil
+	})
+	c.Fake().PrependReactor("create", "localsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.LocalSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Name != "broken-2"
+		return true, review, nil
+	})
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	dynamicClient.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() != ProbeName {
+			t.Errorf("unexpected create of %s", obj.GetName())
+		}
+		data, _, _ := unstructured.NestedString(obj.Object, "binaryData", combiner.PartialItemKey)
+		if len(data) > 1024*1024 {
+			return true, nil, apierrors.NewRequestEntityTooLargeError("limit is 1048576")
+		}
+		return true, obj, nil
+	})
+
+	metadata, closeMetadata := newMetadataClient(t, c.Client())
+	defer closeMetadata()
+
+	run := func(blockBytes int64, verifyContent bool) []Finding {
+		return Run(context.Background(), &Options{
+			Namespace:      "default",
+			ServiceAccount: "my-app",
+			BlockBytes:     blockBytes,
+			Client:         c.Client(),
+			Dynamic:        dynamicClient,
+			Metadata:       metadata,
+			VerifyContent:  verifyContent,
+		})
+	}
+	findings := run(client.DefaultChunkSize, false)
+	var out bytes.Buffer
+	if err := Print(&out, findings); err != nil {
+		t.Fatal(err)
+	}
+	t.Log(out.String())
+
+	want := []struct {
+		check    string
+		status   Status
+		contains string
+	}{
+		{"caller access", Fail, "cannot delete configmaps"},
+		{"service account my-app", Fail, "cannot get broken-2 of megaconfigmap broken"},
+		{"service account my-app", Pass, "can read megaconfigmap good"},
+		{"quota", Warn, "largest megaconfigmap needs 4"},
+		{"request size", Pass, "accepts"},
+		{"megaconfigmap broken", Pass, "3 partial configmaps exist"},
+		{"megaconfigmap missing", Fail, "missing-2 are missing; recreate it"},
+		{"megaconfigmap good", Pass, "3 partial configmaps exist"},
+	}
+	for _, w := range want {
+		var found bool
+		for _, f := range findings {
+			if f.Check == w.check && f.Status == w.status && strings.Contains(f.Message, w.contains) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no [%s] %s: ...%s...", w.status, w.check, w.contains)
+		}
+	}
+	if n := Failed(findings); n != 3 {
+		t.Errorf("Failed() = %d, want 3", n)
+	}
+	for _, action := range c.Fake().Actions() {
+		if action.Matches("get", "configmaps") && action.(k8stesting.GetAction).GetName() == "good-0" {
+			t.Error("partial configmaps are downloaded without VerifyContent")
+		}
+	}
+
+	for _, f := range run(client.DefaultChunkSize, true) {
+		if f.Check == "megaconfigmap broken" && (f.Status != Fail || !strings.Contains(f.Message, "update broken")) {
+			t.Errorf("corrupted chunk is not reported with VerifyContent: %+v", f)
+		}
+		if f.Check == "megaconfigmap good" && (f.Status != Pass || !strings.Contains(f.Message, "3 partial configmaps are intact")) {
+			t.Errorf("unexpected finding with VerifyContent: %+v", f)
+		}
+	}
+
+	for _, f := range run(2*1024*1024, false) {
+		if f.Check == "request size" && f.Status != Fail {
+			t.Errorf("oversized block is not reported: %+v", f)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	c := megaconfigmaptest.New(t, &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "configmaps"},
+		Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceConfigMaps: resource.MustParse("4")}},
+		Status: corev1.ResourceQuotaStatus{
+			Used: corev1.ResourceList{corev1.ResourceConfigMaps: resource.MustParse("4")},
+		},
+	})
+	defer c.Cleanup()
+	mcm := c.Create("default", "my-conf", "conf.txt", []byte("0123456789"), client.WithChunkSize(4))
+
+	findings := checkQuota(&Options{Namespace: "default", Client: c.Client()}, []*client.MegaConfigMap{mcm})
+	if len(findings) != 1 || findings[0].Status != Fail {
+		t.Errorf("exhausted quota is not reported: %+v", findings)
+	}
+}
+
+// newMetadataClient returns a REST client listing configmaps of k8s as PartialObjectMetadataList,
+// which the fake clientset does not support
+func newMetadataClient(t *testing.T, k8s kubernetes.Interface) (rest.Interface, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")[0]
+		configMaps, err := k8s.CoreV1().ConfigMaps(namespace).List(metav1.ListOptions{LabelSelector: r.URL.Query().Get("labelSelector")})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list := metav1.PartialObjectMetadataList{TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadataList"}}
+		for _, cm := range configMaps.Items {
+			list.Items = append(list.Items, metav1.PartialObjectMetadata{ObjectMeta: cm.ObjectMeta})
+		}
+		json.NewEncoder(w).Encode(&list)
+	}))
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientset.CoreV1().RESTClient(), server.Close
+}
+
+func TestCheckRequestSize(t *testing.T) {
+	gk := schema.GroupKind{Kind: "ConfigMap"}
+	tests := []struct {
+		name     string
+		err      error
+		status   Status
+		contains string
+		lower    bool
+	}{
+		{
+			name:   "accepted",
+			status: Pass,
+		},
+		{
+			name:   "request entity too large",
+			err:    apierrors.NewRequestEntityTooLargeError("limit is 1048576"),
+			status: Fail,
+			lower:  true,
+		},
+		{
+			name:   "too long",
+			err:    apierrors.NewInvalid(gk, ProbeName, field.ErrorList{field.TooLong(field.NewPath("binaryData"), "", 1048576)}),
+			status: Fail,
+			lower:  true,
+		},
+		{
+			name:     "other invalid",
+			err:      apierrors.NewInvalid(gk, ProbeName, field.ErrorList{field.Invalid(field.NewPath("metadata", "name"), ProbeName, "denied by policy")}),
+			status:   Fail,
+			contains: "422 Invalid",
+		},
+		{
+			name:     "internal error",
+			err:      apierrors.NewInternalError(errors.New("etcd is down")),
+			status:   Fail,
+			contains: "500 InternalError",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+			dynamicClient.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, action.(k8stesting.CreateAction).GetObject(), tt.err
+			})
+			f := checkRequestSize(&Options{Namespace: "default", BlockBytes: 1024, Dynamic: dynamicClient})
+			if f.Status != tt.status || !strings.Contains(f.Message, tt.contains) {
+				t.Errorf("checkRequestSize() = %+v", f)
+			}
+			if lower := strings.Contains(f.Message, "lower --block-bytes"); lower != tt.lower {
+				t.Errorf("checkRequestSize() = %+v; suggesting lower --block-bytes is %v, want %v", f, lower, tt.lower)
+			}
+		})
+	}
+}
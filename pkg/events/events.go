@@ -0,0 +1,72 @@
+package events
+
+import (
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Recorder creates Kubernetes Events synchronously.
+// client-go's broadcaster is asynchronous and loses events when a short-lived process exits,
+// so megaconfigmap components create Events by themselves.
+type Recorder struct {
+	k8s       kubernetes.Interface
+	component string
+	host      string
+}
+
+// NewRecorder creates a Recorder that reports events as component
+func NewRecorder(k8s kubernetes.Interface, component string) *Recorder {
+	host, _ := os.Hostname()
+	return &Recorder{
+		k8s:       k8s,
+		component: component,
+		host:      host,
+	}
+}
+
+// Eventf records an event about the object referred by ref
+func (r *Recorder) Eventf(ref *corev1.ObjectReference, eventType, reason, messageFmt string, args ...interface{}) error {
+	now := metav1.Now()
+	_, err := r.k8s.CoreV1().Events(ref.Namespace).Create(&corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: ref.Namespace,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: r.component, Host: r.host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	return err
+}
+
+// ConfigMapReference returns a reference to the ConfigMap namespace/name
+func ConfigMapReference(namespace, name string, uid types.UID) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Namespace:  namespace,
+		Name:       name,
+		UID:        uid,
+	}
+}
+
+// PodReference returns a reference to the Pod namespace/name
+func PodReference(namespace, name string, uid types.UID) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  namespace,
+		Name:       name,
+		UID:        uid,
+	}
+}
@@ -0,0 +1,198 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the API version of the function config
+	APIVersion = "megaconfigmap.io/v1alpha1"
+	// Kind is the kind of the function config
+	Kind = "MegaConfigMapGenerator"
+
+	resourceListAPIVersion = "config.kubernetes.io/v1"
+	resourceListKind       = "ResourceList"
+)
+
+// Config is the function config of the generator
+type Config struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// MegaConfigMaps are the megaconfigmaps to generate in the namespace of the config
+	MegaConfigMaps []MegaConfigMapArgs `json:"megaConfigMaps"`
+	// BlockBytes is the block size of partial configmaps. Zero chooses the sizes automatically.
+	BlockBytes int64 `json:"blockBytes,omitempty"`
+	// Compression is none or gzip. The default is none.
+	Compression string `json:"compression,omitempty"`
+	// Options are the generator options compatible with configMapGenerator
+	Options Options `json:"options,omitempty"`
+}
+
+// MegaConfigMapArgs describes a megaconfigmap to generate
+type MegaConfigMapArgs struct {
+	// Name is the name of the megaconfigmap before the hash suffix is appended
+	Name string `json:"name"`
+	// File is the path of the file, relative to the working directory of the function
+	File string `json:"file"`
+	// FileName is the name of the combined file. The default is the base name of File.
+	FileName string `json:"fileName,omitempty"`
+}
+
+// Options are the generator options
+type Options struct {
+	// DisableNameSuffixHash keeps the names as they are
+	DisableNameSuffixHash bool `json:"disableNameSuffixHash,omitempty"`
+}
+
+type resourceList struct {
+	APIVersion     string                   `json:"apiVersion"`
+	Kind           string                   `json:"kind"`
+	Items          []map[string]interface{} `json:"items"`
+	FunctionConfig map[string]interface{}   `json:"functionConfig,omitempty"`
+}
+
+// Run reads a ResourceList from r, appends the megaconfigmaps of its function config to the items,
+// rewrites references to them in workloads and writes the ResourceList to w
+func Run(r io.Reader, w io.Writer) error {
+	input, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var list resourceList
+	if err := yaml.Unmarshal(input, &list); err != nil {
+		return fmt.Errorf("failed to decode ResourceList; %w", err)
+	}
+	if list.Kind != resourceListKind {
+		return fmt.Errorf("input must be a %s, got %q", resourceListKind, list.Kind)
+	}
+	cfg, err := parseConfig(list.FunctionConfig)
+	if err != nil {
+		return err
+	}
+
+	items, names, err := Generate(cfg)
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		if err := RewriteReferences(item, cfg.Namespace, names); err != nil {
+			return err
+		}
+	}
+	list.APIVersion = resourceListAPIVersion
+	list.Items = append(list.Items, items...)
+	output, err := yaml.Marshal(&list)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(output)
+	return err
+}
+
+func parseConfig(functionConfig map[string]interface{}) (*Config, error) {
+	data, err := json.Marshal(functionConfig)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode %s; %w", Kind, err)
+	}
+	if cfg.Kind != Kind {
+		return nil, fmt.Errorf("functionConfig must be a %s, got %q", Kind, cfg.Kind)
+	}
+	return &cfg, nil
+}
+
+// Generate returns the megaconfigmaps and their partial configmaps of cfg as rendered by
+// kubectl megaconfigmap create --dry-run=client, and the generated names by the names in cfg
+func Generate(cfg *Config) ([]map[string]interface{}, map[string]string, error) {
+	if len(cfg.Namespace) == 0 {
+		return nil, nil, errors.New("metadata.namespace is required, since the megaconfigmap ID depends on it")
+	}
+	blockBytes := cfg.BlockBytes
+	if err := client.ValidateChunkSize(blockBytes); err != nil {
+		return nil, nil, fmt.Errorf("invalid blockBytes; %w", err)
+	}
+	opts := []client.Option{client.WithChunkSize(blockBytes)}
+	switch cfg.Compression {
+	case "", "none":
+	case combiner.EncodingGzip:
+		opts = append(opts, client.WithCompression(combiner.EncodingGzip))
+	default:
+		return nil, nil, fmt.Errorf("compression must be none or gzip, got %s", cfg.Compression)
+	}
+	c := client.New(nil, opts...)
+
+	var items []map[string]interface{}
+	names := make(map[string]string)
+	for _, args := range cfg.MegaConfigMaps {
+		if len(args.Name) == 0 || len(args.File) == 0 {
+			return nil, nil, errors.New("name and file are required in megaConfigMaps")
+		}
+		if _, ok := names[args.Name]; ok {
+			return nil, nil, fmt.Errorf("megaconfigmap %s is specified twice", args.Name)
+		}
+		data, err := ioutil.ReadFile(args.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileName := args.FileName
+		if len(fileName) == 0 {
+			fileName = filepath.Base(args.File)
+		}
+		name := args.Name
+		if !cfg.Options.DisableNameSuffixHash {
+			name += "-" + nameSuffixHash(data, fileName, cfg.Compression, blockBytes)
+		}
+		names[args.Name] = name
+
+		objects, err := c.Render(cfg.Namespace, name, fileName, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, cm := range objects {
+			u, err := client.Unstructured(cm)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, u)
+		}
+	}
+	return items, names, nil
+}
+
+// nameSuffixHash returns a hash of everything rendered into the megaconfigmap,
+// encoded in the same way as configMapGenerator of kustomize
+func nameSuffixHash(data []byte, fileName, compression string, blockBytes int64) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%x\n%s\n%s\n%d\n", sha256.Sum256(data), fileName, compression, blockBytes)
+	enc := []byte(fmt.Sprintf("%x", h.Sum(nil))[:10])
+	for i := range enc {
+		switch enc[i] {
+		case '0':
+			enc[i] = 'g'
+		case '1':
+			enc[i] = 'h'
+		case '3':
+			enc[i] = 'k'
+		case 'a':
+			enc[i] = 'm'
+		case 'e':
+			enc[i] = 't'
+		}
+	}
+	return string(enc)
+}
@@ -0,0 +1,193 @@
+package generator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const input = `apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  apiVersion: megaconfigmap.io/v1alpha1
+  kind: MegaConfigMapGenerator
+  metadata:
+    name: generator
+    namespace: default
+  blockBytes: 65536
+  megaConfigMaps:
+  - name: my-conf
+    file: %s
+items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: injected
+  spec:
+    template:
+      metadata:
+        annotations:
+          megaconfigmap.io/inject: my-conf:/etc/app/data,other:/etc/other/
+      spec:
+        containers:
+        - name: main
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: combiner
+    namespace: default
+  spec:
+    initContainers:
+    - name: combiner
+      args: ["-megaconfigmap=my-conf", "-share-dir=/data"]
+    containers:
+    - name: main
+      args: ["--megaconfigmap", "my-conf"]
+    volumes:
+    - name: csi
+      csi:
+        driver: csi.megaconfigmap.io
+        volumeAttributes:
+          megaconfigmap: my-conf
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: other-namespace
+    namespace: other
+  spec:
+    initContainers:
+    - name: combiner
+      args: ["-megaconfigmap=my-conf"]
+`
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data")
+	data := bytes.Repeat([]byte("abcdefghij"), 13108) // 3 partial configmaps
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = Run(strings.NewReader(strings.Replace(input, "%s", file, 1)), &out)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	var list resourceList
+	if err := yaml.Unmarshal(out.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 7 || list.FunctionConfig == nil {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+
+	name := "my-conf-" + nameSuffixHash(data, "data", "", 65536)
+	rendered, err := client.New(nil, client.WithChunkSize(65536)).Render("default", name, "data", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, cm := range rendered {
+		want, err := client.Unstructured(cm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantYAML, _ := yaml.Marshal(want)
+		gotYAML, _ := yaml.Marshal(list.Items[3+i])
+		if !bytes.Equal(gotYAML, wantYAML) {
+			t.Errorf("generated %s, want %s", gotYAML, wantYAML)
+		}
+	}
+
+	deployment := list.Items[0]
+	annotation, _, _ := unstructured.NestedString(deployment, "spec", "template", "metadata", "annotations", inject.InjectAnnotation)
+	if annotation != name+":/etc/app/data,other:/etc/other/" {
+		t.Errorf("annotation = %s", annotation)
+	}
+	pod := list.Items[1]
+	initContainers, _, _ := unstructured.NestedSlice(pod, "spec", "initContainers")
+	args, _, _ := unstructured.NestedStringSlice(initContainers[0].(map[string]interface{}), "args")
+	if args[0] != "-megaconfigmap="+name || args[1] != "-share-dir=/data" {
+		t.Errorf("init container args = %v", args)
+	}
+	containers, _, _ := unstructured.NestedSlice(pod, "spec", "containers")
+	args, _, _ = unstructured.NestedStringSlice(containers[0].(map[string]interface{}), "args")
+	if args[1] != name {
+		t.Errorf("container args = %v", args)
+	}
+	volumes, _, _ := unstructured.NestedSlice(pod, "spec", "volumes")
+	attribute, _, _ := unstructured.NestedString(volumes[0].(map[string]interface{}), "csi", "volumeAttributes", "megaconfigmap")
+	if attribute != name {
+		t.Errorf("volume attribute = %s", attribute)
+	}
+	initContainers, _, _ = unstructured.NestedSlice(list.Items[2], "spec", "initContainers")
+	args, _, _ = unstructured.NestedStringSlice(initContainers[0].(map[string]interface{}), "args")
+	if args[0] != "-megaconfigmap=my-conf" {
+		t.Errorf("pod in another namespace is rewritten: %v", args)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(file, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{MegaConfigMaps: []MegaConfigMapArgs{{Name: "my-conf", File: file, FileName: "app.conf"}}}
+	cfg.Kind = Kind
+	if _, _, err := Generate(cfg); err == nil {
+		t.Error("Generate() should fail without namespace")
+	}
+
+	cfg.Namespace = "default"
+	cfg.Options.DisableNameSuffixHash = true
+	items, names, err := Generate(cfg)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if names["my-conf"] != "my-conf" || len(items) != 2 {
+		t.Errorf("unexpected output: %v %v", names, items)
+	}
+
+	cfg.Options.DisableNameSuffixHash = false
+	_, names, err = Generate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := names["my-conf"]
+	cfg.Compression = "gzip"
+	_, names, err = Generate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == "my-conf" || names["my-conf"] == first {
+		t.Errorf("hash suffixes are not changed: %s, %s", first, names["my-conf"])
+	}
+
+	cfg.BlockBytes = 4
+	if _, _, err := Generate(cfg); err == nil {
+		t.Error("Generate() should fail for too small blockBytes")
+	}
+	cfg.BlockBytes = 0
+
+	cfg.MegaConfigMaps = append(cfg.MegaConfigMaps, cfg.MegaConfigMaps[0])
+	if _, _, err := Generate(cfg); err == nil {
+		t.Error("Generate() should fail for duplicated names")
+	}
+}
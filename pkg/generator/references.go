@@ -0,0 +1,108 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/dulltz/megaconfigmap/pkg/csi"
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var megaConfigMapFlags = []string{"-megaconfigmap", "--megaconfigmap"}
+
+// RewriteReferences renames megaconfigmaps referred to by the workload obj in namespace to names[name].
+// It rewrites the inject annotation, the -megaconfigmap flag of containers and CSI volume attributes.
+// Objects without a namespace are regarded as in namespace, since it may be set later by kustomize.
+func RewriteReferences(obj map[string]interface{}, namespace string, names map[string]string) error {
+	u := &unstructured.Unstructured{Object: obj}
+	if ns := u.GetNamespace(); len(ns) > 0 && ns != namespace {
+		return nil
+	}
+	path, ok := inject.PodTemplatePath(u.GetKind())
+	if !ok {
+		return nil
+	}
+
+	annotations, _, err := unstructured.NestedStringMap(obj, append(path, "metadata", "annotations")...)
+	if err != nil {
+		return err
+	}
+	if value, ok := annotations[inject.InjectAnnotation]; ok {
+		targets, err := inject.ParseTargets(value)
+		if err != nil {
+			return err
+		}
+		for i := range targets {
+			if name, ok := names[targets[i].MegaConfigMap]; ok {
+				targets[i].MegaConfigMap = name
+			}
+		}
+		err = unstructured.SetNestedField(obj, inject.FormatTargets(targets), append(path, "metadata", "annotations", inject.InjectAnnotation)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, err := unstructured.NestedSlice(obj, append(path, "spec", field)...)
+		if err != nil {
+			return err
+		}
+		for _, c := range containers {
+			if c, ok := c.(map[string]interface{}); ok {
+				rewriteArgs(c, names)
+			}
+		}
+		if len(containers) > 0 {
+			if err := unstructured.SetNestedSlice(obj, containers, append(path, "spec", field)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	volumes, _, err := unstructured.NestedSlice(obj, append(path, "spec", "volumes")...)
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes {
+		v, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		driver, _, _ := unstructured.NestedString(v, "csi", "driver")
+		current, _, _ := unstructured.NestedString(v, "csi", "volumeAttributes", csi.MegaConfigMapAttribute)
+		if name, ok := names[current]; ok && driver == csi.DriverName {
+			if err := unstructured.SetNestedField(v, name, "csi", "volumeAttributes", csi.MegaConfigMapAttribute); err != nil {
+				return err
+			}
+		}
+	}
+	if len(volumes) > 0 {
+		return unstructured.SetNestedSlice(obj, volumes, append(path, "spec", "volumes")...)
+	}
+	return nil
+}
+
+// rewriteArgs renames the -megaconfigmap flag of the combiner in the container c
+func rewriteArgs(c map[string]interface{}, names map[string]string) {
+	args, _, _ := unstructured.NestedStringSlice(c, "args")
+	changed := false
+	for i, arg := range args {
+		for _, flag := range megaConfigMapFlags {
+			if strings.HasPrefix(arg, flag+"=") {
+				if name, ok := names[strings.TrimPrefix(arg, flag+"=")]; ok {
+					args[i] = flag + "=" + name
+					changed = true
+				}
+			} else if arg == flag && i+1 < len(args) {
+				if name, ok := names[args[i+1]]; ok {
+					args[i+1] = name
+					changed = true
+				}
+			}
+		}
+	}
+	if changed {
+		unstructured.SetNestedStringSlice(c, args, "args")
+	}
+}
@@ -0,0 +1,271 @@
+package inject
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// InjectAnnotation lists megaconfigmaps to inject as comma separated NAME:PATH pairs.
+	// PATH is the path of the combined file in the containers, or a directory if it ends with "/".
+	InjectAnnotation = "megaconfigmap.io/inject"
+	// ContainersAnnotation lists the names of containers to mount megaconfigmaps. The default is all containers.
+	ContainersAnnotation = "megaconfigmap.io/inject-containers"
+
+	// DefaultImage is the default combiner image
+	DefaultImage = "quay.io/dulltz/megaconfigmap-combiner:latest"
+
+	namePrefix = "megaconfigmap-"
+	shareDir   = "/megaconfigmap"
+)
+
+// Target is a megaconfigmap to be combined into the pod
+type Target struct {
+	// MegaConfigMap is the name of the megaconfigmap
+	MegaConfigMap string
+	// Path is the path of the combined file, or a directory if it ends with "/"
+	Path string
+}
+
+// Config configures the injected combiner
+type Config struct {
+	// Image is the combiner image
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy is the pull policy of the combiner image
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Resources are the resource requirements of the combiner
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// SecurityContext is the security context of the combiner
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	// ServiceAccountName is set to pods using the default service account if it is not empty
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Args are extra arguments of the combiner
+	Args []string `json:"args,omitempty"`
+}
+
+// ParseTargets parses the value of InjectAnnotation
+func ParseTargets(value string) ([]Target, error) {
+	var targets []Target
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || !path.IsAbs(kv[1]) {
+			return nil, fmt.Errorf("invalid %s %q; must be NAME:/ABSOLUTE/PATH", InjectAnnotation, item)
+		}
+		if seen[kv[0]] {
+			return nil, fmt.Errorf("megaconfigmap %s is specified twice in %s", kv[0], InjectAnnotation)
+		}
+		seen[kv[0]] = true
+		targets = append(targets, Target{MegaConfigMap: kv[0], Path: kv[1]})
+	}
+	if len(targets) == 0 {
+		return nil, errors.New(InjectAnnotation + " is empty")
+	}
+	return targets, nil
+}
+
+// FormatTargets formats targets as the value of InjectAnnotation
+func FormatTargets(targets []Target) string {
+	items := make([]string, len(targets))
+	for i, t := range targets {
+		items[i] = t.MegaConfigMap + ":" + t.Path
+	}
+	return strings.Join(items, ",")
+}
+
+// ParseContainers parses the value of ContainersAnnotation
+func ParseContainers(value string) []string {
+	var containers []string
+	for _, c := range strings.Split(value, ",") {
+		c = strings.TrimSpace(c)
+		if len(c) > 0 {
+			containers = append(containers, c)
+		}
+	}
+	return containers
+}
+
+// PodTemplatePath returns the field path of the pod template in objects of kind.
+// The path of a Pod is empty, since the pod itself has the metadata and the spec.
+func PodTemplatePath(kind string) ([]string, bool) {
+	switch kind {
+	case "Pod":
+		return []string{}, true
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		return []string{"spec", "template"}, true
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template"}, true
+	}
+	return nil, false
+}
+
+// UpdatePodSpec calls update with the pod spec in the template of the workload obj, and writes it back if update returns true.
+// It returns false if obj has no pod template or update does not change it.
+func UpdatePodSpec(obj map[string]interface{}, update func(*corev1.PodSpec) (bool, error)) (bool, error) {
+	u := &unstructured.Unstructured{Object: obj}
+	templatePath, ok := PodTemplatePath(u.GetKind())
+	if !ok {
+		return false, nil
+	}
+	specPath := append(templatePath, "spec")
+	raw, ok, err := unstructured.NestedMap(obj, specPath...)
+	if err != nil || !ok {
+		return false, err
+	}
+	var spec corev1.PodSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+		return false, err
+	}
+	changed, err := update(&spec)
+	if err != nil || !changed {
+		return false, err
+	}
+	updated, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+	if err != nil {
+		return false, err
+	}
+	return true, unstructured.SetNestedField(obj, updated, specPath...)
+}
+
+// Name returns the name of the init container and the volume for the megaconfigmap
+func Name(megaConfigMapName string) string {
+	name := namePrefix + megaConfigMapName
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	sum := fmt.Sprintf("%x", sha1.Sum([]byte(megaConfigMapName)))[:10]
+	return name[:validation.DNS1123LabelMaxLength-len(sum)-1] + "-" + sum
+}
+
+// PodSpec injects combiner init containers, share volumes and mounts for targets into spec.
+// Containers are the names of containers to mount the volumes, or all containers if it is empty.
+// Injecting the same targets again does not change spec.
+func PodSpec(spec *corev1.PodSpec, targets []Target, containers []string, cfg *Config) error {
+	mountTo := make(map[string]bool)
+	for _, c := range containers {
+		mountTo[c] = true
+	}
+	found := 0
+	for _, c := range spec.Containers {
+		if mountTo[c.Name] {
+			found++
+		}
+	}
+	if found != len(mountTo) {
+		return fmt.Errorf("some containers in %v are not found in the pod", containers)
+	}
+
+	for _, t := range targets {
+		name := Name(t.MegaConfigMap)
+		mount := corev1.VolumeMount{Name: name, MountPath: t.Path, ReadOnly: true}
+		var fileName string
+		if !strings.HasSuffix(t.Path, "/") {
+			fileName = path.Base(t.Path)
+			mount.SubPath = fileName
+		}
+
+		setVolume(spec, corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+		spec.InitContainers = setContainer(spec.InitContainers,
+			CombinerContainer(name, t.MegaConfigMap, fileName, corev1.VolumeMount{Name: name, MountPath: shareDir}, cfg))
+		for i := range spec.Containers {
+			c := &spec.Containers[i]
+			if len(mountTo) > 0 && !mountTo[c.Name] {
+				continue
+			}
+			setVolumeMount(c, mount)
+		}
+	}
+
+	if len(cfg.ServiceAccountName) > 0 && (len(spec.ServiceAccountName) == 0 || spec.ServiceAccountName == "default") {
+		spec.ServiceAccountName = cfg.ServiceAccountName
+	}
+	return nil
+}
+
+// CombinerContainer returns the init container named name combining megaConfigMap into the volume mounted by shareMount.
+// The file is named fileName, or the file name of the megaconfigmap if it is empty.
+func CombinerContainer(name, megaConfigMap, fileName string, shareMount corev1.VolumeMount, cfg *Config) corev1.Container {
+	image := cfg.Image
+	if len(image) == 0 {
+		image = DefaultImage
+	}
+	args := []string{"-megaconfigmap=" + megaConfigMap, "-share-dir=" + shareMount.MountPath}
+	if len(fileName) > 0 {
+		args = append(args, "-file-name="+fileName)
+	}
+	args = append(args, cfg.Args...)
+	return corev1.Container{
+		Name:            name,
+		Image:           image,
+		ImagePullPolicy: cfg.ImagePullPolicy,
+		Command:         []string{"/combiner"},
+		Args:            args,
+		Env: []corev1.EnvVar{
+			fieldEnv("POD_NAME", "metadata.name"),
+			fieldEnv("POD_NAMESPACE", "metadata.namespace"),
+			fieldEnv("POD_UID", "metadata.uid"),
+		},
+		Resources:       cfg.Resources,
+		SecurityContext: cfg.SecurityContext,
+		VolumeMounts:    []corev1.VolumeMount{shareMount},
+	}
+}
+
+func fieldEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name:      name,
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath}},
+	}
+}
+
+func setVolume(spec *corev1.PodSpec, v corev1.Volume) {
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == v.Name {
+			spec.Volumes[i] = v
+			return
+		}
+	}
+	spec.Volumes = append(spec.Volumes, v)
+}
+
+func setContainer(containers []corev1.Container, c corev1.Container) []corev1.Container {
+	for i := range containers {
+		if containers[i].Name == c.Name {
+			containers[i] = c
+			return containers
+		}
+	}
+	return append(containers, c)
+}
+
+func setVolumeMount(c *corev1.Container, m corev1.VolumeMount) {
+	for i := range c.VolumeMounts {
+		if c.VolumeMounts[i].Name == m.Name {
+			c.VolumeMounts[i] = m
+			return
+		}
+	}
+	c.VolumeMounts = append(c.VolumeMounts, m)
+}
+
+func removeVolumeMount(c *corev1.Container, name string) {
+	mounts := c.VolumeMounts[:0]
+	for _, m := range c.VolumeMounts {
+		if m.Name != name {
+			mounts = append(mounts, m)
+		}
+	}
+	c.VolumeMounts = mounts
+}
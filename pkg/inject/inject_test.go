@@ -0,0 +1,113 @@
+package inject
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []Target
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			value: "my-conf:/etc/app/model.bin, other:/data/",
+			want:  []Target{{MegaConfigMap: "my-conf", Path: "/etc/app/model.bin"}, {MegaConfigMap: "other", Path: "/data/"}},
+		},
+		{
+			name:    "invalid: relative path",
+			value:   "my-conf:model.bin",
+			wantErr: true,
+		},
+		{
+			name:    "invalid: no path",
+			value:   "my-conf",
+			wantErr: true,
+		},
+		{
+			name:    "invalid: duplicated",
+			value:   "my-conf:/a,my-conf:/b",
+			wantErr: true,
+		},
+		{
+			name:    "invalid: empty",
+			value:   " ",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseTargets(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodSpec(t *testing.T) {
+	spec := &corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "main"}, {Name: "sidecar"}},
+	}
+	targets := []Target{{MegaConfigMap: "my-conf", Path: "/etc/app/model.bin"}}
+	cfg := &Config{Image: "combiner:test", ServiceAccountName: "megaconfigmap"}
+
+	err := PodSpec(spec, targets, []string{"main"}, cfg)
+	if err != nil {
+		t.Fatalf("PodSpec() error = %v", err)
+	}
+	if len(spec.InitContainers) != 1 || spec.InitContainers[0].Image != "combiner:test" {
+		t.Fatalf("unexpected init containers: %v", spec.InitContainers)
+	}
+	if !reflect.DeepEqual(spec.InitContainers[0].Args, []string{"-megaconfigmap=my-conf", "-share-dir=/megaconfigmap", "-file-name=model.bin"}) {
+		t.Errorf("unexpected args: %v", spec.InitContainers[0].Args)
+	}
+	if len(spec.Volumes) != 1 || spec.Volumes[0].EmptyDir == nil {
+		t.Errorf("unexpected volumes: %v", spec.Volumes)
+	}
+	want := []corev1.VolumeMount{{Name: "megaconfigmap-my-conf", MountPath: "/etc/app/model.bin", SubPath: "model.bin", ReadOnly: true}}
+	if !reflect.DeepEqual(spec.Containers[0].VolumeMounts, want) {
+		t.Errorf("unexpected mounts of main: %v", spec.Containers[0].VolumeMounts)
+	}
+	if len(spec.Containers[1].VolumeMounts) != 0 {
+		t.Errorf("unexpected mounts of sidecar: %v", spec.Containers[1].VolumeMounts)
+	}
+	if spec.ServiceAccountName != "megaconfigmap" {
+		t.Errorf("ServiceAccountName = %v", spec.ServiceAccountName)
+	}
+
+	injected := spec.DeepCopy()
+	err = PodSpec(spec, targets, []string{"main"}, cfg)
+	if err != nil {
+		t.Fatalf("PodSpec() error = %v", err)
+	}
+	if !reflect.DeepEqual(spec, injected) {
+		t.Errorf("PodSpec() is not idempotent: %v", spec)
+	}
+
+	err = PodSpec(spec, targets, []string{"missing"}, cfg)
+	if err == nil {
+		t.Error("PodSpec() should fail for a missing container")
+	}
+}
+
+func TestName(t *testing.T) {
+	if got := Name("my-conf"); got != "megaconfigmap-my-conf" {
+		t.Errorf("Name() = %v", got)
+	}
+	long := Name(strings.Repeat("a", 100))
+	if len(long) > 63 || long == Name(strings.Repeat("a", 101)) {
+		t.Errorf("Name() = %v", long)
+	}
+}
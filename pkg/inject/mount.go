@@ -0,0 +1,131 @@
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// Mount is where a container mounts a megaconfigmap
+type Mount struct {
+	// Container is the name of the container, or empty for all containers
+	Container string
+	// Path is the path of the combined file, or a directory if it ends with "/"
+	Path string
+}
+
+// workloadTypes are the types of workloads used to compute strategic merge patches
+var workloadTypes = map[string]interface{}{
+	"Pod":         corev1.Pod{},
+	"Deployment":  appsv1.Deployment{},
+	"StatefulSet": appsv1.StatefulSet{},
+	"DaemonSet":   appsv1.DaemonSet{},
+	"ReplicaSet":  appsv1.ReplicaSet{},
+	"Job":         batchv1.Job{},
+	"CronJob":     batchv1beta1.CronJob{},
+}
+
+// ParseMount parses [CONTAINER:]PATH
+func ParseMount(value string) (Mount, error) {
+	var m Mount
+	if i := strings.Index(value, ":"); i >= 0 {
+		m.Container, m.Path = value[:i], value[i+1:]
+	} else {
+		m.Path = value
+	}
+	if !path.IsAbs(m.Path) || (strings.Contains(value, ":") && len(m.Container) == 0) {
+		return m, fmt.Errorf("invalid mount %q; must be [CONTAINER:]/ABSOLUTE/PATH", value)
+	}
+	return m, nil
+}
+
+// MountPodSpec injects a combiner of megaConfigMap into spec and mounts the combined file as mounts.
+// It updates an existing injection of megaConfigMap, removing its mounts from containers not in mounts,
+// and returns false if spec is not changed.
+func MountPodSpec(spec *corev1.PodSpec, megaConfigMap string, mounts []Mount, cfg *Config) (bool, error) {
+	var fileName string
+	for _, m := range mounts {
+		if strings.HasSuffix(m.Path, "/") {
+			continue
+		}
+		if len(fileName) > 0 && path.Base(m.Path) != fileName {
+			return false, fmt.Errorf("file names of mounts differ: %s and %s", fileName, path.Base(m.Path))
+		}
+		fileName = path.Base(m.Path)
+	}
+	// the init container is replaced by each mount, so mounts of the file come last to keep its name
+	mounts = append([]Mount{}, mounts...)
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.HasSuffix(mounts[i].Path, "/") && !strings.HasSuffix(mounts[j].Path, "/")
+	})
+
+	original := spec.DeepCopy()
+	targeted := make(map[string]bool)
+	for _, m := range mounts {
+		targeted[m.Container] = true
+	}
+	if !targeted[""] {
+		name := Name(megaConfigMap)
+		for i := range spec.Containers {
+			if !targeted[spec.Containers[i].Name] {
+				removeVolumeMount(&spec.Containers[i], name)
+			}
+		}
+	}
+	for _, m := range mounts {
+		var containers []string
+		if len(m.Container) > 0 {
+			containers = []string{m.Container}
+		}
+		err := PodSpec(spec, []Target{{MegaConfigMap: megaConfigMap, Path: m.Path}}, containers, cfg)
+		if err != nil {
+			return false, err
+		}
+	}
+	return !reflect.DeepEqual(original, spec), nil
+}
+
+// StrategicMergePatch returns the strategic merge patch applying update to the pod template of the workload obj.
+// It returns false if update does not change it.
+func StrategicMergePatch(obj map[string]interface{}, update func(*corev1.PodSpec) (bool, error)) ([]byte, bool, error) {
+	u := &unstructured.Unstructured{Object: obj}
+	dataStruct, ok := workloadTypes[u.GetKind()]
+	if !ok {
+		return nil, false, fmt.Errorf("%s is not a workload", u.GetKind())
+	}
+	// compare the pod specs converted in the same way so that defaults of the conversion are not in the patch
+	original := u.DeepCopy().Object
+	_, err := UpdatePodSpec(original, func(*corev1.PodSpec) (bool, error) { return true, nil })
+	if err != nil {
+		return nil, false, err
+	}
+	modified := u.DeepCopy().Object
+	changed, err := UpdatePodSpec(modified, update)
+	if err != nil || !changed {
+		return nil, false, err
+	}
+
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, false, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, false, err
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(originalJSON, modifiedJSON, dataStruct)
+	if err != nil {
+		return nil, false, err
+	}
+	return patch, true, nil
+}
@@ -0,0 +1,128 @@
+package inject
+
+import (
+	"encoding/json"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+func TestParseMount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Mount
+		wantErr bool
+	}{
+		{value: "main:/etc/app/", want: Mount{Container: "main", Path: "/etc/app/"}},
+		{value: "/etc/app/model.bin", want: Mount{Path: "/etc/app/model.bin"}},
+		{value: "main:etc/app", wantErr: true},
+		{value: ":/etc/app", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMount(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMount(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseMount(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMountPodSpec(t *testing.T) {
+	spec := &corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "main"},
+		{Name: "sidecar", VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/logs"}}},
+	}}
+	mounts := []Mount{{Container: "main", Path: "/etc/app/model.bin"}, {Container: "sidecar", Path: "/data/"}}
+	changed, err := MountPodSpec(spec, "my-conf", mounts, &Config{})
+	if err != nil || !changed {
+		t.Fatalf("MountPodSpec() = %v, %v", changed, err)
+	}
+	if len(spec.InitContainers) != 1 || len(spec.Volumes) != 1 {
+		t.Fatalf("unexpected spec: %+v", spec)
+	}
+	if args := spec.InitContainers[0].Args; args[len(args)-1] != "-file-name=model.bin" {
+		t.Errorf("file name is not kept: %v", args)
+	}
+	if m := spec.Containers[0].VolumeMounts[0]; m.MountPath != "/etc/app/model.bin" || m.SubPath != "model.bin" {
+		t.Errorf("unexpected mount of main: %+v", m)
+	}
+	if m := spec.Containers[1].VolumeMounts[1]; m.MountPath != "/data/" || len(m.SubPath) > 0 {
+		t.Errorf("unexpected mount of sidecar: %+v", m)
+	}
+
+	changed, err = MountPodSpec(spec, "my-conf", mounts, &Config{})
+	if err != nil || changed {
+		t.Errorf("second MountPodSpec() = %v, %v", changed, err)
+	}
+	changed, err = MountPodSpec(spec, "my-conf", []Mount{{Container: "main", Path: "/etc/app/other.bin"}}, &Config{})
+	if err != nil || !changed {
+		t.Fatalf("MountPodSpec() = %v, %v", changed, err)
+	}
+	if len(spec.InitContainers) != 1 || len(spec.Containers[0].VolumeMounts) != 1 || spec.Containers[0].VolumeMounts[0].SubPath != "other.bin" {
+		t.Errorf("injection is not updated: %+v", spec)
+	}
+	// sidecar is not in the mounts any more
+	if mounts := spec.Containers[1].VolumeMounts; len(mounts) != 1 || mounts[0].Name != "logs" {
+		t.Errorf("mounts of sidecar are not removed: %+v", mounts)
+	}
+	changed, err = MountPodSpec(spec, "my-conf", []Mount{{Path: "/etc/app/other.bin"}}, &Config{})
+	if err != nil || !changed || len(spec.Containers[1].VolumeMounts) != 2 {
+		t.Errorf("MountPodSpec() for all containers = %v, %v: %+v", changed, err, spec.Containers[1].VolumeMounts)
+	}
+
+	_, err = MountPodSpec(spec, "my-conf", []Mount{{Path: "/a/x"}, {Path: "/b/y"}}, &Config{})
+	if err == nil {
+		t.Error("MountPodSpec() should fail for different file names")
+	}
+}
+
+func TestStrategicMergePatch(t *testing.T) {
+	deployment := &appsv1.Deployment{}
+	deployment.APIVersion = "apps/v1"
+	deployment.Kind = "Deployment"
+	deployment.Name = "app"
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "main", Image: "alpine"}}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(spec *corev1.PodSpec) (bool, error) {
+		return MountPodSpec(spec, "my-conf", []Mount{{Path: "/etc/app/"}}, &Config{})
+	}
+
+	patch, changed, err := StrategicMergePatch(obj, update)
+	if err != nil || !changed {
+		t.Fatalf("StrategicMergePatch() = %s, %v, %v", patch, changed, err)
+	}
+	original, err := json.Marshal(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, appsv1.Deployment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result appsv1.Deployment
+	if err := json.Unmarshal(patched, &result); err != nil {
+		t.Fatal(err)
+	}
+	spec := result.Spec.Template.Spec
+	if len(spec.InitContainers) != 1 || len(spec.Volumes) != 1 || spec.Containers[0].Image != "alpine" || len(spec.Containers[0].VolumeMounts) != 1 {
+		t.Errorf("unexpected patched spec: %+v", spec)
+	}
+
+	var patchedObj map[string]interface{}
+	if err := json.Unmarshal(patched, &patchedObj); err != nil {
+		t.Fatal(err)
+	}
+	patch, changed, err = StrategicMergePatch(patchedObj, update)
+	if err != nil || changed {
+		t.Errorf("second StrategicMergePatch() = %s, %v, %v", patch, changed, err)
+	}
+}
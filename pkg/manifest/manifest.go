@@ -0,0 +1,85 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Document is a document of a YAML manifest stream
+type Document struct {
+	// Raw is the document as read, including comments
+	Raw []byte
+	// Object is the decoded document, or nil if it is empty
+	Object map[string]interface{}
+	// Changed means Object has been modified, so it is written instead of Raw
+	Changed bool
+	// Replacements are written instead of the document if they are not empty
+	Replacements []map[string]interface{}
+}
+
+// Read reads a YAML or JSON manifest stream
+func Read(r io.Reader) ([]*Document, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	var docs []*Document
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		doc := &Document{Raw: raw}
+		if err := yaml.Unmarshal(raw, &doc.Object); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest; %w", err)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// Write writes docs as a YAML manifest stream. Unchanged documents are written as they were read.
+func Write(w io.Writer, docs []*Document) error {
+	var out bytes.Buffer
+	for _, doc := range docs {
+		switch {
+		case len(doc.Replacements) > 0:
+			for _, obj := range doc.Replacements {
+				if err := writeObject(&out, obj); err != nil {
+					return err
+				}
+			}
+		case doc.Changed:
+			if err := writeObject(&out, doc.Object); err != nil {
+				return err
+			}
+		case doc.Object != nil:
+			if !bytes.HasPrefix(doc.Raw, []byte("---")) {
+				out.WriteString("---\n")
+			}
+			out.Write(doc.Raw)
+			if !bytes.HasSuffix(doc.Raw, []byte("\n")) {
+				out.WriteString("\n")
+			}
+		}
+	}
+	if out.Len() == 0 {
+		return errors.New("no manifests are given")
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func writeObject(w io.Writer, obj map[string]interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "---\n%s", data)
+	return err
+}
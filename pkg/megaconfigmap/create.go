@@ -1,32 +1,33 @@
 package megaconfigmap
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"math"
 	"os"
-	"path/filepath"
+	"time"
 
-	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/events"
 	"github.com/spf13/cobra"
-	"golang.org/x/sync/errgroup"
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
-const (
-	defaultBlockBytes = int64(400 * 1024)
-)
+const component = "kubectl-megaconfigmap"
 
 var (
 	createExample = `
 	# create megaconfigmap from file
 	%[1]s megaconfigmap create my-config --from-file=<file-name>
+
+	# create compressed megaconfigmap
+	%[1]s megaconfigmap create my-config --from-file=<file-name> --compression=gzip
+
+	# render megaconfigmap as manifests to apply by GitOps tools instead of creating it
+	%[1]s megaconfigmap create my-config --from-file=<file-name> -n my-namespace --dry-run=client -o yaml > my-config.yaml
 `
 )
 
@@ -34,142 +35,205 @@ var (
 type CreateOptions struct {
 	configFlags *genericclioptions.ConfigFlags
 	genericclioptions.IOStreams
-	k8s *kubernetes.Clientset
+	k8s kubernetes.Interface
 
 	megaConfigMapName string
 	blockBytes        int64
-	outputFile        string
+	compression       string
+	parallelism       int
+	retries           int
 	sourceFile        string
+	dryRun            string
+	output            string
+	maxBytes          int64
+	namespaceBudget   int64
+	quiet             bool
+
+	progress *progressPrinter
 }
 
 func (o *CreateOptions) getNamespace() string {
-	if o.configFlags == nil || len(*o.configFlags.Namespace) == 0 {
-		return "default"
+	return getNamespace(o.configFlags)
+}
+
+// newClient returns a client configured by the flags
+func (o *CreateOptions) newClient() (*client.Client, error) {
+	if err := client.ValidateChunkSize(o.blockBytes); err != nil {
+		return nil, fmt.Errorf("invalid --block-bytes; %w", err)
+	}
+	opts := []client.Option{
+		client.WithChunkSize(o.blockBytes),
+		client.WithParallelism(o.parallelism),
+		client.WithRetry(o.retries, client.DefaultRetryInterval),
 	}
-	return *o.configFlags.Namespace
+	if o.dryRun != "client" {
+		if o.k8s == nil {
+			clientset, err := newClientset()
+			if err != nil {
+				return nil, err
+			}
+			o.k8s = clientset
+		}
+		opts = append(opts, client.WithEventRecorder(events.NewRecorder(o.k8s, component)))
+		if !o.quiet {
+			opts = append(opts, client.WithProgress(o.reportProgress))
+		}
+	}
+	switch o.compression {
+	case "none":
+	case "gzip":
+		opts = append(opts, client.WithCompression("gzip"))
+	default:
+		return nil, fmt.Errorf("--compression must be none or gzip, got %s", o.compression)
+	}
+	return client.New(o.k8s, opts...), nil
 }
 
 // Create MegaConfigMap
 func (o *CreateOptions) Create() error {
-	if len(o.sourceFile) > 0 {
-		return o.createFromFile()
+	if len(o.sourceFile) == 0 {
+		return errors.New("currently, --from-file is required")
 	}
-	return errors.New("currently, --from-file is required")
-}
-
-func (o *CreateOptions) createFromFile() error {
-	f, err := os.Open(o.sourceFile)
+	c, err := o.newClient()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	stat, err := f.Stat()
+	data, fileName, err := o.readSourceFile()
 	if err != nil {
 		return err
 	}
-	if stat.IsDir() {
-		return errors.New("--from-file not support directory")
+	if o.dryRun == "client" {
+		cms, err := c.Render(o.getNamespace(), o.megaConfigMapName, fileName, data)
+		if err != nil {
+			return err
+		}
+		objects := make([]runtime.Object, len(cms))
+		for i, cm := range cms {
+			objects[i] = cm
+		}
+		return printObjects(o.Out, objects, o.output)
 	}
-	o.outputFile = stat.Name()
-	checkSum, err := o.getCheckSum()
+	ctx := context.Background()
+	// refuse before writing anything, since a creation failing halfway leaves nothing usable
+	plan, err := c.Plan(o.getNamespace(), o.megaConfigMapName, data)
 	if err != nil {
 		return err
 	}
-
-	fmt.Printf("creating megaconfigmap %s...\n", o.megaConfigMapName)
-	master, err := o.createMasterConfigMap(checkSum)
+	if err := c.Preflight(ctx, plan, o.limits()); err != nil {
+		return err
+	}
+	if current, err := c.Get(ctx, o.getNamespace(), o.megaConfigMapName); err == nil && current.Pending {
+		o.printStatus("resuming the upload of megaconfigmap %s...\n", o.megaConfigMapName)
+	} else {
+		o.printStatus("creating megaconfigmap %s...\n", o.megaConfigMapName)
+	}
+	start := time.Now()
+	o.progress = newProgressPrinter(o.ErrOut)
+	mcm, err := c.CreateFromPlan(ctx, plan, fileName)
+	o.progress.finish()
 	if err != nil {
+		err = o.reportUploadError(err)
+		if current, gerr := c.Get(ctx, o.getNamespace(), o.megaConfigMapName); gerr == nil && current.Pending {
+			fmt.Fprintf(o.ErrOut, "the upload of megaconfigmap %s is pending; run the same command again to resume it, or delete it\n", o.megaConfigMapName)
+		}
 		return err
 	}
+	if o.output == "json" {
+		return printSummary(o.Out, mcm, time.Since(start))
+	}
+	fmt.Fprintf(o.Out, "megaconfigmap %s created with %d partial configmaps\n", mcm.Name, len(mcm.Manifest.Chunks))
+	return nil
+}
 
-	numPartials := int64(math.Ceil(float64(stat.Size()) / float64(o.blockBytes)))
-	fmt.Printf("creating %d partial configmaps from %s...\n", numPartials, o.megaConfigMapName)
-	var g errgroup.Group
-	for i := int64(0); i < numPartials; i++ {
-		i := i
-		g.Go(func() error {
-			buf := make([]byte, o.blockBytes)
-			n, err := f.ReadAt(buf, i*o.blockBytes)
-			if err != io.EOF && err != nil {
-				defer o.k8s.CoreV1().ConfigMaps(o.getNamespace()).Delete(master.Name, &metav1.DeleteOptions{})
-				return err
-			}
-			err = o.createPartialConfigMap(buf[:n], i, checkSum, master)
-			if err != nil {
-				defer o.k8s.CoreV1().ConfigMaps(o.getNamespace()).Delete(master.Name, &metav1.DeleteOptions{})
-				return err
-			}
-			return nil
-		})
+// reportProgress is a callback of client.WithProgress
+func (o *CreateOptions) reportProgress(progress client.Progress) {
+	if o.progress != nil {
+		o.progress.report(progress)
 	}
-	return g.Wait()
 }
 
-func (o *CreateOptions) getCheckSum() (string, error) {
-	data, err := ioutil.ReadFile(o.sourceFile)
-	if err != nil {
-		return "", err
+// reportUploadError writes each partial configmap that failed to be written to ErrOut,
+// and returns a short error instead of a *client.UploadError listing all of them
+func (o *CreateOptions) reportUploadError(err error) error {
+	var uploadErr *client.UploadError
+	if !errors.As(err, &uploadErr) {
+		return err
+	}
+	for _, partialErr := range uploadErr.Errors {
+		fmt.Fprintf(o.ErrOut, "failed to write %s: %v\n", partialErr.Name, partialErr.Err)
 	}
-	return combiner.MapID(data, o.getNamespace(), o.megaConfigMapName), nil
+	return fmt.Errorf("failed to write %d of %d partial configmaps", len(uploadErr.Errors), uploadErr.Total)
 }
 
-func (o *CreateOptions) createPartialConfigMap(data []byte, order int64, sum string, master *corev1.ConfigMap) error {
-	_, err := o.k8s.CoreV1().ConfigMaps(o.getNamespace()).Create(&corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: o.getNamespace(),
-			Name:      fmt.Sprintf("%s-%d", o.megaConfigMapName, order),
-			Labels: map[string]string{
-				combiner.IDLabel:       sum,
-				combiner.OrderLabel:    fmt.Sprintf("%d", order),
-				combiner.FileNameLabel: o.outputFile,
-			},
-			OwnerReferences: []metav1.OwnerReference{{
-				APIVersion: "v1",
-				Kind:       "ConfigMap",
-				Name:       master.Name,
-				UID:        master.UID,
-			}},
-		},
-		Data: map[string]string{combiner.PartialItemKey: string(data)},
-	})
-	return err
+// printStatus writes a message to Out unless -o json is given, so that the summary is the only output
+func (o *CreateOptions) printStatus(format string, args ...interface{}) {
+	if len(o.output) == 0 {
+		fmt.Fprintf(o.Out, format, args...)
+	}
 }
 
-func (o *CreateOptions) createMasterConfigMap(sum string) (*corev1.ConfigMap, error) {
-	_, err := o.k8s.CoreV1().ConfigMaps(o.getNamespace()).Create(&corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: o.getNamespace(),
-			Name:      o.megaConfigMapName,
-			Labels: map[string]string{
-				combiner.IDLabel:       sum,
-				combiner.FileNameLabel: o.outputFile,
-				combiner.MasterLabel:   "true",
-			},
-		},
-	})
-	if err != nil {
-		return nil, err
+func (o *CreateOptions) validateDryRun() error {
+	switch o.dryRun {
+	case "none":
+		if len(o.output) > 0 && o.output != "json" {
+			return fmt.Errorf("--output must be json without --dry-run=client, got %q", o.output)
+		}
+	case "client":
+		if o.output != "yaml" && o.output != "json" {
+			return fmt.Errorf("--output must be yaml or json, got %q", o.output)
+		}
+	default:
+		return fmt.Errorf("--dry-run must be none or client, got %s", o.dryRun)
 	}
-	return o.k8s.CoreV1().ConfigMaps(o.getNamespace()).Get(o.megaConfigMapName, metav1.GetOptions{})
+	return nil
 }
 
-// NewMegaConfigMapOptions provides an instance of MegaConfigMapOptions with default values
-func NewCreateOptions(streams genericclioptions.IOStreams) (*CreateOptions, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(os.Getenv("HOME"), "/.kube/config"))
+// readSourceFile reads --from-file and returns its content and name
+func (o *CreateOptions) readSourceFile() ([]byte, string, error) {
+	stat, err := os.Stat(o.sourceFile)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if stat.IsDir() {
+		return nil, "", errors.New("--from-file not support directory")
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	data, err := ioutil.ReadFile(o.sourceFile)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	return data, stat.Name(), nil
+}
+
+// NewMegaConfigMapOptions provides an instance of MegaConfigMapOptions with default values
+func NewCreateOptions(streams genericclioptions.IOStreams) (*CreateOptions, error) {
+	// the clientset is created on use, since --dry-run=client works without kubeconfig
 	return &CreateOptions{
 		configFlags: genericclioptions.NewConfigFlags(true),
 		IOStreams:   streams,
-		k8s:         clientset,
 	}, nil
 }
 
+// addFlags adds the flags shared by create and update
+func (o *CreateOptions) addFlags(cmd *cobra.Command) {
+	o.configFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.sourceFile, "from-file", o.sourceFile, "Filename to be stored in megaconfigmap.")
+	cmd.Flags().Int64Var(&o.blockBytes, "block-bytes", client.DefaultChunkSize, "Block size of partial configmaps. 0 chooses the largest sizes fitting in a configmap.")
+	cmd.Flags().StringVar(&o.compression, "compression", "none", "Compression of partial configmaps, none or gzip.")
+	cmd.Flags().IntVar(&o.parallelism, "parallelism", client.DefaultParallelism, "Number of partial configmaps written concurrently.")
+	cmd.Flags().IntVar(&o.retries, "retries", client.DefaultRetries, "Number of retries of a partial configmap failing with a transient error, with exponential backoff.")
+	cmd.Flags().BoolVarP(&o.quiet, "quiet", "q", false, "Do not report the progress of uploads to stderr.")
+}
+
+// addLimitFlags adds the flags of the limits checked before creating megaconfigmaps
+func (o *CreateOptions) addLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&o.maxBytes, "max-bytes", 0, "Maximum bytes stored for the megaconfigmap, including encoding overhead. 0 means no limit.")
+	cmd.Flags().Int64Var(&o.namespaceBudget, "namespace-budget", 0, "Maximum bytes stored for all megaconfigmaps in the namespace. 0 means no limit.")
+}
+
+func (o *CreateOptions) limits() client.Limits {
+	return client.Limits{MaxBytes: o.maxBytes, NamespaceBudget: o.namespaceBudget}
+}
+
 // NewCmdCreate provides a cobra command wrapping MegaCreateOptions
 func NewCmdCreate(streams genericclioptions.IOStreams) *cobra.Command {
 	o, err := NewCreateOptions(streams)
@@ -186,11 +250,15 @@ func NewCmdCreate(streams genericclioptions.IOStreams) *cobra.Command {
 				return fmt.Errorf("exactly one NAME is required, got %d", len(args))
 			}
 			o.megaConfigMapName = args[0]
+			if err := o.validateDryRun(); err != nil {
+				return err
+			}
 			return o.Create()
 		},
 	}
-	o.configFlags.AddFlags(cmd.Flags())
-	cmd.Flags().StringVar(&o.sourceFile, "from-file", o.sourceFile, "Filename to be stored in megaconfigmap.")
-	cmd.Flags().Int64Var(&o.blockBytes, "block-bytes", defaultBlockBytes, "Block size of partial configmaps.")
+	o.addFlags(cmd)
+	o.addLimitFlags(cmd)
+	cmd.Flags().StringVar(&o.dryRun, "dry-run", "none", "Must be none or client. If client, print the megaconfigmap and its partial configmaps without creating them.")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. json prints a summary of the upload. With --dry-run=client, yaml or json prints the objects.")
 	return cmd
 }
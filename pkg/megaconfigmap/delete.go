@@ -0,0 +1,77 @@
+package megaconfigmap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/events"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	deleteExample = `
+	# delete megaconfigmap and its partial configmaps
+	%[1]s megaconfigmap delete my-config
+`
+)
+
+// DeleteOptions provides information required to delete megaconfigmap
+type DeleteOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+	genericclioptions.IOStreams
+	k8s kubernetes.Interface
+
+	megaConfigMapName string
+}
+
+// Delete deletes the megaconfigmap. Its partial configmaps are deleted by the garbage collector.
+func (o *DeleteOptions) Delete() error {
+	if o.k8s == nil {
+		clientset, err := newClientset()
+		if err != nil {
+			return err
+		}
+		o.k8s = clientset
+	}
+	c := client.New(o.k8s, client.WithEventRecorder(events.NewRecorder(o.k8s, component)))
+	err := c.Delete(context.Background(), getNamespace(o.configFlags), o.megaConfigMapName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.Out, "megaconfigmap %s deleted\n", o.megaConfigMapName)
+	return nil
+}
+
+// NewDeleteOptions provides an instance of DeleteOptions with default values
+func NewDeleteOptions(streams genericclioptions.IOStreams) (*DeleteOptions, error) {
+	return &DeleteOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		IOStreams:   streams,
+	}, nil
+}
+
+// NewCmdDelete provides a cobra command wrapping DeleteOptions
+func NewCmdDelete(streams genericclioptions.IOStreams) *cobra.Command {
+	o, err := NewDeleteOptions(streams)
+	if err != nil {
+		return nil
+	}
+	cmd := &cobra.Command{
+		Use:          "delete my-config [flags]",
+		Short:        "delete megaconfigmap",
+		Example:      fmt.Sprintf(deleteExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("exactly one NAME is required, got %d", len(args))
+			}
+			o.megaConfigMapName = args[0]
+			return o.Delete()
+		},
+	}
+	o.configFlags.AddFlags(cmd.Flags())
+	return cmd
+}
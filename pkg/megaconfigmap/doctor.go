@@ -0,0 +1,100 @@
+package megaconfigmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/doctor"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	doctorExample = `
+	# check that you can create megaconfigmaps and that all megaconfigmaps in the namespace are intact
+	%[1]s megaconfigmap doctor -n my-namespace
+
+	# also check that the service account of combiners can read my-config
+	%[1]s megaconfigmap doctor my-config --serviceaccount my-app
+
+	# download my-config to verify its content
+	%[1]s megaconfigmap doctor my-config --verify-content
+`
+)
+
+// DoctorOptions provides information required to diagnose megaconfigmaps
+type DoctorOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+	genericclioptions.IOStreams
+	k8s     kubernetes.Interface
+	dynamic dynamic.Interface
+
+	megaConfigMapNames []string
+	serviceAccount     string
+	blockBytes         int64
+	verifyContent      bool
+}
+
+// Doctor runs the checks and prints the findings. It fails if any check fails.
+func (o *DoctorOptions) Doctor() error {
+	if o.blockBytes <= 0 {
+		return errors.New("--block-bytes must be positive")
+	}
+	if o.k8s == nil {
+		clientset, err := newClientset()
+		if err != nil {
+			return err
+		}
+		o.k8s = clientset
+	}
+	if o.dynamic == nil {
+		dynamicClient, err := newDynamicClient()
+		if err != nil {
+			return err
+		}
+		o.dynamic = dynamicClient
+	}
+	findings := doctor.Run(context.Background(), &doctor.Options{
+		Namespace:      getNamespace(o.configFlags),
+		MegaConfigMaps: o.megaConfigMapNames,
+		ServiceAccount: o.serviceAccount,
+		BlockBytes:     o.blockBytes,
+		Client:         o.k8s,
+		Dynamic:        o.dynamic,
+		VerifyContent:  o.verifyContent,
+	})
+	if err := doctor.Print(o.Out, findings); err != nil {
+		return err
+	}
+	if n := doctor.Failed(findings); n > 0 {
+		return fmt.Errorf("%d checks failed", n)
+	}
+	return nil
+}
+
+// NewCmdDoctor provides a cobra command wrapping DoctorOptions
+func NewCmdDoctor(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &DoctorOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		IOStreams:   streams,
+	}
+	cmd := &cobra.Command{
+		Use:          "doctor [my-config...] [flags]",
+		Short:        "check access, quota, request size and the health of megaconfigmaps",
+		Example:      fmt.Sprintf(doctorExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			o.megaConfigMapNames = args
+			return o.Doctor()
+		},
+	}
+	o.configFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", "", "Service account of the pods running the combiner to check.")
+	cmd.Flags().Int64Var(&o.blockBytes, "block-bytes", client.MaxChunkSize, "Block size of partial configmaps to probe.")
+	cmd.Flags().BoolVar(&o.verifyContent, "verify-content", false, "Download every partial configmap and verify its content. By default only their metadata is checked.")
+	return cmd
+}
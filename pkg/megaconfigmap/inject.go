@@ -0,0 +1,178 @@
+package megaconfigmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	"github.com/dulltz/megaconfigmap/pkg/manifest"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	injectExample = `
+	# add the combiner of my-conf to workloads in deploy.yaml and mount the file to the main container
+	%[1]s megaconfigmap inject -f deploy.yaml --megaconfigmap my-conf --mount main:/etc/app/model.bin > injected.yaml
+
+	# patch an existing deployment in the cluster to mount the directory to all containers
+	%[1]s megaconfigmap inject deployment/my-app --live --megaconfigmap my-conf --mount /etc/app/
+`
+
+	liveResources = map[string]schema.GroupVersionResource{
+		"deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+		"deploy":      {Group: "apps", Version: "v1", Resource: "deployments"},
+		"statefulset": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+		"sts":         {Group: "apps", Version: "v1", Resource: "statefulsets"},
+		"daemonset":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+		"ds":          {Group: "apps", Version: "v1", Resource: "daemonsets"},
+		"replicaset":  {Group: "apps", Version: "v1", Resource: "replicasets"},
+		"rs":          {Group: "apps", Version: "v1", Resource: "replicasets"},
+		"job":         {Group: "batch", Version: "v1", Resource: "jobs"},
+		"cronjob":     {Group: "batch", Version: "v1beta1", Resource: "cronjobs"},
+		"cj":          {Group: "batch", Version: "v1beta1", Resource: "cronjobs"},
+	}
+)
+
+// InjectOptions provides information required to inject a megaconfigmap into workloads
+type InjectOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+	genericclioptions.IOStreams
+	dynamic dynamic.Interface
+
+	filename          string
+	live              bool
+	megaConfigMapName string
+	mounts            []string
+	image             string
+	imagePullPolicy   string
+	serviceAccount    string
+}
+
+func (o *InjectOptions) update(spec *corev1.PodSpec) (bool, error) {
+	var mounts []inject.Mount
+	for _, value := range o.mounts {
+		m, err := inject.ParseMount(value)
+		if err != nil {
+			return false, err
+		}
+		mounts = append(mounts, m)
+	}
+	cfg := &inject.Config{
+		Image:              o.image,
+		ImagePullPolicy:    corev1.PullPolicy(o.imagePullPolicy),
+		ServiceAccountName: o.serviceAccount,
+	}
+	return inject.MountPodSpec(spec, o.megaConfigMapName, mounts, cfg)
+}
+
+// Inject rewrites the workloads in --filename and writes them to Out
+func (o *InjectOptions) Inject() error {
+	var r io.Reader = o.In
+	if o.filename != "-" {
+		f, err := os.Open(o.filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	docs, err := manifest.Read(r)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		doc.Changed, err = inject.UpdatePodSpec(doc.Object, o.update)
+		if err != nil {
+			return err
+		}
+	}
+	return manifest.Write(o.Out, docs)
+}
+
+// InjectLive patches the workload TYPE/NAME in the cluster
+func (o *InjectOptions) InjectLive(resource string) error {
+	kv := strings.SplitN(resource, "/", 2)
+	if len(kv) != 2 || len(kv[1]) == 0 {
+		return fmt.Errorf("invalid workload %q; must be TYPE/NAME", resource)
+	}
+	gvr, ok := liveResources[strings.TrimSuffix(strings.ToLower(kv[0]), "s")]
+	if !ok {
+		gvr, ok = liveResources[strings.ToLower(kv[0])]
+	}
+	if !ok {
+		return fmt.Errorf("%s is not a supported workload type", kv[0])
+	}
+	if o.dynamic == nil {
+		dynamicClient, err := newDynamicClient()
+		if err != nil {
+			return err
+		}
+		o.dynamic = dynamicClient
+	}
+	client := o.dynamic.Resource(gvr).Namespace(getNamespace(o.configFlags))
+	obj, err := client.Get(kv[1], metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	patch, changed, err := inject.StrategicMergePatch(obj.Object, o.update)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Fprintf(o.Out, "%s unchanged\n", resource)
+		return nil
+	}
+	_, err = client.Patch(kv[1], types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.Out, "%s patched\n", resource)
+	return nil
+}
+
+// NewCmdInject provides a cobra command wrapping InjectOptions
+func NewCmdInject(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &InjectOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		IOStreams:   streams,
+	}
+	cmd := &cobra.Command{
+		Use:          "inject (-f FILENAME | TYPE/NAME --live) --megaconfigmap NAME --mount [CONTAINER:]PATH [flags]",
+		Short:        "inject the combiner of megaconfigmap into workloads",
+		Example:      fmt.Sprintf(injectExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(o.megaConfigMapName) == 0 || len(o.mounts) == 0 {
+				return errors.New("--megaconfigmap and --mount are required")
+			}
+			if o.live {
+				if len(args) != 1 || len(o.filename) > 0 {
+					return errors.New("exactly one TYPE/NAME without --filename is required with --live")
+				}
+				return o.InjectLive(args[0])
+			}
+			if len(args) != 0 || len(o.filename) == 0 {
+				return errors.New("--filename is required without --live")
+			}
+			return o.Inject()
+		},
+	}
+	o.configFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.filename, "filename", "f", "", "Manifest file of workloads to rewrite, or - for stdin.")
+	cmd.Flags().BoolVar(&o.live, "live", false, "Patch the workload TYPE/NAME in the cluster instead of rewriting a file.")
+	cmd.Flags().StringVar(&o.megaConfigMapName, "megaconfigmap", "", "Name of the megaconfigmap to inject.")
+	cmd.Flags().StringArrayVar(&o.mounts, "mount", nil, `[CONTAINER:]PATH to mount the combined file, or the directory if PATH ends with "/". All containers if CONTAINER is omitted.`)
+	cmd.Flags().StringVar(&o.image, "image", inject.DefaultImage, "Combiner image.")
+	cmd.Flags().StringVar(&o.imagePullPolicy, "image-pull-policy", "", "Pull policy of the combiner image.")
+	cmd.Flags().StringVar(&o.serviceAccount, "service-account", "", "Service account set to pods using the default one. It needs to read the megaconfigmap.")
+	return cmd
+}
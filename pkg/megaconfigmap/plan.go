@@ -0,0 +1,88 @@
+package megaconfigmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var (
+	planExample = `
+	# show what creating my-config writes and check it against the limits
+	%[1]s megaconfigmap plan my-config --from-file=<file-name> --compression=gzip
+
+	# check against a budget of 500MB for all megaconfigmaps in the namespace
+	%[1]s megaconfigmap plan --from-file=<file-name> --namespace-budget=500000000
+`
+)
+
+// Plan prints what creating the megaconfigmap writes, and checks it as create does before writing anything
+func (o *CreateOptions) Plan() error {
+	if len(o.sourceFile) == 0 {
+		return errors.New("currently, --from-file is required")
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	data, fileName, err := o.readSourceFile()
+	if err != nil {
+		return err
+	}
+	name := o.megaConfigMapName
+	if len(name) == 0 {
+		// the name only changes the names of the partial configmaps in the manifest
+		name = fileName
+	}
+	plan, err := c.Plan(o.getNamespace(), name, data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.Out, "objects:      %d configmaps (%d partial configmaps and the megaconfigmap)\n", plan.Objects, plan.Objects-1)
+	fmt.Fprintf(o.Out, "content:      %d bytes\n", plan.Bytes)
+	if len(plan.Encoding) > 0 {
+		fmt.Fprintf(o.Out, "compression:  %s saves %d bytes (%.1f%%)\n", plan.Encoding, plan.Savings(), percent(plan.Savings(), plan.Bytes))
+	}
+	fmt.Fprintf(o.Out, "stored:       %d bytes including encoding overhead\n", plan.StoredBytes)
+	if err := c.Preflight(context.Background(), plan, o.limits()); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.Out, "megaconfigmap %s fits in the limits of %s\n", name, plan.Namespace)
+	return nil
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// NewCmdPlan provides a cobra command wrapping CreateOptions to plan megaconfigmap
+func NewCmdPlan(streams genericclioptions.IOStreams) *cobra.Command {
+	o, err := NewCreateOptions(streams)
+	if err != nil {
+		return nil
+	}
+	cmd := &cobra.Command{
+		Use:          "plan [my-config] --from-file [flags]",
+		Short:        "show the objects and bytes of megaconfigmap and check them against quotas and limits",
+		Example:      fmt.Sprintf(planExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("at most one NAME is allowed, got %d", len(args))
+			}
+			if len(args) == 1 {
+				o.megaConfigMapName = args[0]
+			}
+			return o.Plan()
+		},
+	}
+	o.addFlags(cmd)
+	o.addLimitFlags(cmd)
+	return cmd
+}
@@ -0,0 +1,90 @@
+package megaconfigmap
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	"github.com/dulltz/megaconfigmap/pkg/postrender"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var (
+	postRenderExample = `
+	# convert ConfigMaps over 1MB rendered by helm
+	helm install my-release my-chart -n my-namespace --post-renderer %[1]s-megaconfigmap --post-renderer-args post-render --post-renderer-args --namespace=my-namespace
+`
+)
+
+// PostRenderOptions provides information required to convert oversized ConfigMaps in a manifest stream
+type PostRenderOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+	genericclioptions.IOStreams
+
+	threshold       int
+	blockBytes      int64
+	compression     string
+	image           string
+	imagePullPolicy string
+	serviceAccount  string
+}
+
+// PostRender converts the manifest stream on In and writes it to Out
+func (o *PostRenderOptions) PostRender() error {
+	if o.threshold < 0 {
+		return errors.New("--threshold must not be negative")
+	}
+	if err := client.ValidateChunkSize(o.blockBytes); err != nil {
+		return fmt.Errorf("invalid --block-bytes; %w", err)
+	}
+	opts := []client.Option{client.WithChunkSize(o.blockBytes)}
+	switch o.compression {
+	case "none":
+	case "gzip":
+		opts = append(opts, client.WithCompression("gzip"))
+	default:
+		return fmt.Errorf("--compression must be none or gzip, got %s", o.compression)
+	}
+	return postrender.Run(o.In, o.Out, &postrender.Options{
+		Namespace: *o.configFlags.Namespace,
+		Threshold: o.threshold,
+		Client:    client.New(nil, opts...),
+		Inject: &inject.Config{
+			Image:              o.image,
+			ImagePullPolicy:    corev1.PullPolicy(o.imagePullPolicy),
+			ServiceAccountName: o.serviceAccount,
+		},
+	})
+}
+
+// NewCmdPostRender provides a cobra command wrapping PostRenderOptions
+func NewCmdPostRender(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &PostRenderOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		IOStreams:   streams,
+	}
+	cmd := &cobra.Command{
+		Use:          "post-render [flags]",
+		Short:        "convert oversized ConfigMaps in a manifest stream into megaconfigmaps",
+		Long:         "post-render reads a manifest stream on stdin, replaces ConfigMaps over --threshold with megaconfigmaps, patches pod templates mounting them to combine the files and writes the stream to stdout.",
+		Example:      fmt.Sprintf(postRenderExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("no arguments are allowed, got %d", len(args))
+			}
+			return o.PostRender()
+		},
+	}
+	o.configFlags.AddFlags(cmd.Flags())
+	cmd.Flags().IntVar(&o.threshold, "threshold", postrender.DefaultThreshold, "ConfigMaps holding more bytes than this are converted.")
+	cmd.Flags().Int64Var(&o.blockBytes, "block-bytes", client.DefaultChunkSize, "Block size of partial configmaps. 0 chooses the largest sizes fitting in a configmap.")
+	cmd.Flags().StringVar(&o.compression, "compression", "none", "Compression of partial configmaps, none or gzip.")
+	cmd.Flags().StringVar(&o.image, "image", inject.DefaultImage, "Combiner image.")
+	cmd.Flags().StringVar(&o.imagePullPolicy, "image-pull-policy", "", "Pull policy of the combiner image.")
+	cmd.Flags().StringVar(&o.serviceAccount, "service-account", "", "Service account set to pods using the default one. It needs to read configmaps.")
+	return cmd
+}
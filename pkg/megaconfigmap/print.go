@@ -0,0 +1,94 @@
+package megaconfigmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// printObjects writes objects, such as configmaps rendered by client.Render, to w as a YAML stream or a JSON List
+func printObjects(w io.Writer, objects []runtime.Object, format string) error {
+	items := make([]map[string]interface{}, len(objects))
+	for i, obj := range objects {
+		u, err := client.Unstructured(obj)
+		if err != nil {
+			return err
+		}
+		items[i] = u
+	}
+
+	switch format {
+	case "yaml":
+		for _, item := range items {
+			data, err := yaml.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		list := map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"metadata":   metav1.ListMeta{},
+			"items":      items,
+		}
+		data, err := json.MarshalIndent(list, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// Version is the version of kubectl-megaconfigmap, set by -ldflags "-X github.com/dulltz/megaconfigmap/pkg/megaconfigmap.Version=..."
+var Version = "dev"
+
+// summary describes an uploaded megaconfigmap for -o json
+type summary struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	// ResourceVersion is that of the megaconfigmap after the upload
+	ResourceVersion string `json:"resourceVersion"`
+	Chunks          int    `json:"chunks"`
+	Bytes           int64  `json:"bytes"`
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+	Version  string  `json:"version"`
+}
+
+// printSummary writes the summary of mcm uploaded in duration to w as JSON
+func printSummary(w io.Writer, mcm *client.MegaConfigMap, duration time.Duration) error {
+	s := summary{
+		Name:      mcm.Name,
+		Namespace: mcm.Namespace,
+		ID:        mcm.ID,
+		Duration:  duration.Seconds(),
+		Version:   Version,
+	}
+	if mcm.ConfigMap != nil {
+		s.ResourceVersion = mcm.ConfigMap.ResourceVersion
+	}
+	if mcm.Manifest != nil {
+		s.Chunks = len(mcm.Manifest.Chunks)
+		s.Bytes = mcm.Manifest.Bytes
+	}
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,35 @@
+package megaconfigmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPrintSummary(t *testing.T) {
+	mcm := &client.MegaConfigMap{
+		Namespace: "default",
+		Name:      "my-conf",
+		ID:        "abc",
+		Manifest:  &combiner.Manifest{Bytes: 7, Chunks: make([]combiner.Chunk, 3)},
+		ConfigMap: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "42"}},
+	}
+	var buf bytes.Buffer
+	if err := printSummary(&buf, mcm, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var s summary
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	want := summary{Name: "my-conf", Namespace: "default", ID: "abc", ResourceVersion: "42", Chunks: 3, Bytes: 7, Duration: 1.5, Version: Version}
+	if s != want {
+		t.Errorf("summary = %+v, want %+v", s, want)
+	}
+}
@@ -0,0 +1,85 @@
+package megaconfigmap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+)
+
+const (
+	// terminalInterval is how often the progress line is redrawn on a terminal
+	terminalInterval = 100 * time.Millisecond
+	// lineInterval is how often a progress line is written when not on a terminal, e.g. in CI logs
+	lineInterval = 5 * time.Second
+)
+
+// progressPrinter writes the progress of uploads to w.
+// On a terminal it redraws a single line; otherwise it writes a plain line periodically.
+type progressPrinter struct {
+	w        io.Writer
+	terminal bool
+	start    time.Time
+	last     time.Time
+	drawn    bool
+	now      func() time.Time
+}
+
+func newProgressPrinter(w io.Writer) *progressPrinter {
+	p := &progressPrinter{w: w, now: time.Now}
+	if f, ok := w.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil {
+			p.terminal = stat.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	p.start = p.now()
+	return p
+}
+
+// report is a callback of client.WithProgress
+func (p *progressPrinter) report(progress client.Progress) {
+	now := p.now()
+	interval := lineInterval
+	if p.terminal {
+		interval = terminalInterval
+	}
+	if progress.Chunks < progress.TotalChunks && now.Sub(p.last) < interval {
+		return
+	}
+	p.last = now
+	line := formatProgress(progress, now.Sub(p.start))
+	if p.terminal {
+		// pad to erase a longer previous line
+		fmt.Fprintf(p.w, "\r%-80s", line)
+		p.drawn = true
+		return
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+// finish ends the line redrawn on a terminal
+func (p *progressPrinter) finish() {
+	if p.drawn {
+		fmt.Fprintln(p.w)
+		p.drawn = false
+	}
+}
+
+// formatProgress formats progress after elapsed, e.g. "uploaded 12/50 chunks, 9.0MiB/37.5MiB, 3.0MiB/s, ETA 12s"
+func formatProgress(progress client.Progress, elapsed time.Duration) string {
+	line := fmt.Sprintf("uploaded %d/%d chunks, %s/%s", progress.Chunks, progress.TotalChunks,
+		combiner.FormatBytes(progress.Bytes), combiner.FormatBytes(progress.TotalBytes))
+	if elapsed <= 0 || progress.Bytes == 0 {
+		return line
+	}
+	rate := float64(progress.Bytes) / elapsed.Seconds()
+	line += fmt.Sprintf(", %s/s", combiner.FormatBytes(int64(rate)))
+	if remaining := progress.TotalBytes - progress.Bytes; remaining > 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
@@ -0,0 +1,55 @@
+package megaconfigmap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+)
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		progress client.Progress
+		elapsed  time.Duration
+		want     string
+	}{
+		{
+			progress: client.Progress{TotalChunks: 4, TotalBytes: 4 << 20},
+			want:     "uploaded 0/4 chunks, 0B/4.0MiB",
+		},
+		{
+			progress: client.Progress{Chunks: 1, TotalChunks: 4, Bytes: 1 << 20, TotalBytes: 4 << 20},
+			elapsed:  2 * time.Second,
+			want:     "uploaded 1/4 chunks, 1.0MiB/4.0MiB, 512.0KiB/s, ETA 6s",
+		},
+		{
+			progress: client.Progress{Chunks: 4, TotalChunks: 4, Bytes: 4 << 20, TotalBytes: 4 << 20},
+			elapsed:  4 * time.Second,
+			want:     "uploaded 4/4 chunks, 4.0MiB/4.0MiB, 1.0MiB/s",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatProgress(tt.progress, tt.elapsed); got != tt.want {
+			t.Errorf("formatProgress(%+v, %v) = %q, want %q", tt.progress, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestProgressPrinter_lines(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newProgressPrinter(buf)
+	now := p.start
+	p.now = func() time.Time { return now }
+	for i := 1; i <= 3; i++ {
+		now = now.Add(time.Second)
+		p.report(client.Progress{Chunks: i, TotalChunks: 3, Bytes: int64(i), TotalBytes: 3})
+	}
+	p.finish()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// the first report after lineInterval and the final report are printed
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "uploaded 1/3") || !strings.HasPrefix(lines[1], "uploaded 3/3") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
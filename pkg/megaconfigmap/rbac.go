@@ -0,0 +1,83 @@
+package megaconfigmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/rbac"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	rbacExample = `
+	# allow the service account my-app to read only my-config
+	%[1]s megaconfigmap rbac my-config --serviceaccount my-app | kubectl apply -f -
+`
+)
+
+// RBACOptions provides information required to print the RBAC of megaconfigmap
+type RBACOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+	genericclioptions.IOStreams
+	k8s kubernetes.Interface
+
+	megaConfigMapName string
+	serviceAccount    string
+	output            string
+}
+
+// Print writes the Role and the RoleBinding for the megaconfigmap to Out
+func (o *RBACOptions) Print() error {
+	if o.k8s == nil {
+		clientset, err := newClientset()
+		if err != nil {
+			return err
+		}
+		o.k8s = clientset
+	}
+	mcm, err := client.New(o.k8s).Get(context.Background(), getNamespace(o.configFlags), o.megaConfigMapName)
+	if err != nil {
+		return err
+	}
+	role, err := rbac.Role(mcm)
+	if err != nil {
+		return err
+	}
+	return printObjects(o.Out, []runtime.Object{role, rbac.RoleBinding(mcm, o.serviceAccount)}, o.output)
+}
+
+// NewCmdRBAC provides a cobra command wrapping RBACOptions
+func NewCmdRBAC(streams genericclioptions.IOStreams) *cobra.Command {
+	o := &RBACOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+		IOStreams:   streams,
+	}
+	cmd := &cobra.Command{
+		Use:          "rbac my-config --serviceaccount SA [flags]",
+		Short:        "print the Role and RoleBinding allowing a service account to read only the megaconfigmap",
+		Example:      fmt.Sprintf(rbacExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("exactly one NAME is required, got %d", len(args))
+			}
+			if len(o.serviceAccount) == 0 {
+				return errors.New("--serviceaccount is required")
+			}
+			if o.output != "yaml" && o.output != "json" {
+				return fmt.Errorf("--output must be yaml or json, got %q", o.output)
+			}
+			o.megaConfigMapName = args[0]
+			return o.Print()
+		},
+	}
+	o.configFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", "", "Service account of the pods running the combiner.")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "yaml", "Output format. One of: yaml|json.")
+	return cmd
+}
@@ -2,16 +2,44 @@ package megaconfigmap
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/dulltz/megaconfigmap/pkg/client"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
 	example = `
 	# create MegaConfigMap from file
 	%[1]s megaconfigmap create --from-file=<file-name>
+
+	# show the objects and bytes of MegaConfigMap and check them against quotas and limits
+	%[1]s megaconfigmap plan my-config --from-file=<file-name>
+
+	# replace the content of MegaConfigMap
+	%[1]s megaconfigmap update my-config --from-file=<file-name>
+
+	# delete MegaConfigMap and its partial configmaps
+	%[1]s megaconfigmap delete my-config
+
+	# inject the combiner of MegaConfigMap into workloads
+	%[1]s megaconfigmap inject -f deploy.yaml --megaconfigmap my-config --mount main:/etc/app/
+
+	# print the Role allowing a service account to read only MegaConfigMap
+	%[1]s megaconfigmap rbac my-config --serviceaccount my-app
+
+	# find out why MegaConfigMap cannot be created or combined
+	%[1]s megaconfigmap doctor my-config --serviceaccount my-app
+
+	# convert oversized ConfigMaps in a manifest stream
+	helm template my-chart | %[1]s megaconfigmap post-render --namespace=my-namespace
 `
 )
 
@@ -36,7 +64,7 @@ func NewCmdMegaConfigMap(streams genericclioptions.IOStreams) (*cobra.Command, e
 		return nil, err
 	}
 	cmd := &cobra.Command{
-		Use:     "megaconfigmap [create,get] [flags]",
+		Use:     "megaconfigmap [create,plan,update,delete,inject,rbac,doctor,post-render] [flags]",
 		Short:   "control megaconfigmap",
 		Example: fmt.Sprintf(example, "kubectl"),
 		RunE: func(c *cobra.Command, args []string) error {
@@ -46,3 +74,35 @@ func NewCmdMegaConfigMap(streams genericclioptions.IOStreams) (*cobra.Command, e
 	o.configFlags.AddFlags(cmd.Flags())
 	return cmd, nil
 }
+
+func getNamespace(configFlags *genericclioptions.ConfigFlags) string {
+	if configFlags == nil || len(*configFlags.Namespace) == 0 {
+		return "default"
+	}
+	return *configFlags.Namespace
+}
+
+func newConfig() (*rest.Config, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join(os.Getenv("HOME"), "/.kube/config"))
+	if err != nil {
+		return nil, err
+	}
+	config.WrapTransport = client.WrapTransport
+	return config, nil
+}
+
+func newClientset() (kubernetes.Interface, error) {
+	config, err := newConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func newDynamicClient() (dynamic.Interface, error) {
+	config, err := newConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
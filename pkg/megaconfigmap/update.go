@@ -0,0 +1,102 @@
+package megaconfigmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/rbac"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var (
+	updateExample = `
+	# replace the content of megaconfigmap with file
+	%[1]s megaconfigmap update my-config --from-file=<file-name>
+`
+)
+
+// Update replaces the content of an existing megaconfigmap.
+// Pods starting while the partial configmaps are being replaced fail to combine and are retried by kubelet.
+func (o *CreateOptions) Update() error {
+	if len(o.sourceFile) == 0 {
+		return errors.New("currently, --from-file is required")
+	}
+	if len(o.output) > 0 && o.output != "json" {
+		return fmt.Errorf("--output must be json, got %q", o.output)
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	data, fileName, err := o.readSourceFile()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// update does not create a new megaconfigmap
+	if _, err := c.Get(ctx, o.getNamespace(), o.megaConfigMapName); err != nil {
+		return err
+	}
+	o.printStatus("updating megaconfigmap %s...\n", o.megaConfigMapName)
+	start := time.Now()
+	o.progress = newProgressPrinter(o.ErrOut)
+	mcm, changed, err := c.Apply(ctx, o.getNamespace(), o.megaConfigMapName, fileName, data)
+	o.progress.finish()
+	if err != nil {
+		err = o.reportUploadError(err)
+		if current, gerr := c.Get(ctx, o.getNamespace(), o.megaConfigMapName); gerr == nil && current.Pending {
+			fmt.Fprintf(o.ErrOut, "the update of megaconfigmap %s is pending; run the same command again to resume it\n", o.megaConfigMapName)
+		}
+		return err
+	}
+	duration := time.Since(start)
+	if !changed {
+		o.printStatus("megaconfigmap %s is unchanged\n", o.megaConfigMapName)
+		return o.printUpdateSummary(mcm, duration)
+	}
+	o.printStatus("megaconfigmap %s updated with %d partial configmaps\n", mcm.Name, len(mcm.Manifest.Chunks))
+	// partial names are deterministic (name-N) but their number can change, so the Role printed by rbac lists the current names
+	refreshed, err := rbac.Refresh(o.k8s, mcm)
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "warning: failed to update role %s; rerun rbac and apply it: %v\n", rbac.RoleName(mcm.Name), err)
+	} else if refreshed {
+		o.printStatus("role %s updated\n", rbac.RoleName(mcm.Name))
+	}
+	return o.printUpdateSummary(mcm, duration)
+}
+
+// printUpdateSummary prints the summary of -o json
+func (o *CreateOptions) printUpdateSummary(mcm *client.MegaConfigMap, duration time.Duration) error {
+	if o.output != "json" {
+		return nil
+	}
+	return printSummary(o.Out, mcm, duration)
+}
+
+// NewCmdUpdate provides a cobra command wrapping CreateOptions to update megaconfigmap
+func NewCmdUpdate(streams genericclioptions.IOStreams) *cobra.Command {
+	o, err := NewCreateOptions(streams)
+	if err != nil {
+		return nil
+	}
+	cmd := &cobra.Command{
+		Use:          "update my-config --from-file [flags]",
+		Short:        "update megaconfigmap",
+		Example:      fmt.Sprintf(updateExample, "kubectl"),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("exactly one NAME is required, got %d", len(args))
+			}
+			o.megaConfigMapName = args[0]
+			return o.Update()
+		},
+	}
+	o.addFlags(cmd)
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "Output format. json prints a summary of the update.")
+	return cmd
+}
@@ -0,0 +1,298 @@
+// Package megaconfigmaptest provides an in-memory API server holding megaconfigmaps for tests.
+// It is backed by the fake clientset of client-go, so no cluster is needed.
+package megaconfigmaptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Cluster is an in-memory API server. Helpers fail the test on unexpected errors.
+type Cluster struct {
+	t         testing.TB
+	clientset *fake.Clientset
+	dirs      []string
+}
+
+// New creates a Cluster holding objects
+func New(t testing.TB, objects ...runtime.Object) *Cluster {
+	return &Cluster{t: t, clientset: fake.NewSimpleClientset(objects...)}
+}
+
+// Client returns the client of the Cluster
+func (c *Cluster) Client() kubernetes.Interface {
+	return c.clientset
+}
+
+// Fake returns the fake clientset of the Cluster to add reactors or inspect actions
+func (c *Cluster) Fake() *fake.Clientset {
+	return c.clientset
+}
+
+// Cleanup removes the directories created by Combine
+func (c *Cluster) Cleanup() {
+	for _, dir := range c.dirs {
+		os.RemoveAll(dir)
+	}
+	c.dirs = nil
+}
+
+// Create creates the megaconfigmap namespace/name holding data as fileName with pkg/client
+func (c *Cluster) Create(namespace, name, fileName string, data []byte, opts ...client.Option) *client.MegaConfigMap {
+	c.t.Helper()
+	mcm, err := client.New(c.clientset, opts...).Create(context.Background(), namespace, name, fileName, data)
+	if err != nil {
+		c.t.Fatalf("failed to create megaconfigmap %s/%s: %v", namespace, name, err)
+	}
+	return mcm
+}
+
+// ConfigMaps returns the megaconfigmap namespace/name holding data as the file "data" and its partial configmaps
+// holding blockBytes each. Unlike Create, nothing is stored, so tests can modify them before Put.
+func ConfigMaps(namespace, name string, data []byte, blockBytes int) []*corev1.ConfigMap {
+	return newConfigMaps(namespace, name, data, blockBytes, true)
+}
+
+// LegacyConfigMaps returns the configmaps of ConfigMaps without the manifest, as created by older versions
+func LegacyConfigMaps(namespace, name string, data []byte, blockBytes int) []*corev1.ConfigMap {
+	return newConfigMaps(namespace, name, data, blockBytes, false)
+}
+
+func newConfigMaps(namespace, name string, data []byte, blockBytes int, withManifest bool) []*corev1.ConfigMap {
+	id := combiner.MapID(data, namespace, name)
+	master := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{
+			combiner.IDLabel: id, combiner.FileNameLabel: "data", combiner.MasterLabel: "true",
+		}},
+	}
+	if withManifest {
+		manifest, err := combiner.NewManifest(data, name, int64(blockBytes)).Marshal()
+		if err != nil {
+			panic(err)
+		}
+		master.Data = map[string]string{combiner.ManifestKey: manifest}
+	}
+	cms := []*corev1.ConfigMap{master}
+	for i := 0; i*blockBytes < len(data); i++ {
+		end := (i + 1) * blockBytes
+		if end > len(data) {
+			end = len(data)
+		}
+		cms = append(cms, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: combiner.PartialName(name, int64(i)), Labels: map[string]string{
+				combiner.IDLabel: id, combiner.OrderLabel: strconv.Itoa(i),
+			}},
+			Data: map[string]string{combiner.PartialItemKey: string(data[i*blockBytes : end])},
+		})
+	}
+	return cms
+}
+
+// Put creates or updates cms. Megaconfigmaps are written last, so that watchers see them with their partial configmaps.
+func (c *Cluster) Put(cms ...*corev1.ConfigMap) {
+	c.t.Helper()
+	var masters []*corev1.ConfigMap
+	for _, cm := range cms {
+		if cm.Labels[combiner.MasterLabel] == "true" {
+			masters = append(masters, cm)
+			continue
+		}
+		c.put(cm)
+	}
+	for _, cm := range masters {
+		c.put(cm)
+	}
+}
+
+func (c *Cluster) put(cm *corev1.ConfigMap) {
+	c.t.Helper()
+	configMaps := c.clientset.CoreV1().ConfigMaps(cm.Namespace)
+	_, err := configMaps.Update(cm)
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(cm)
+	}
+	if err != nil {
+		c.t.Fatalf("failed to put configmap %s/%s: %v", cm.Namespace, cm.Name, err)
+	}
+}
+
+// Partial returns the i-th partial configmap of the megaconfigmap namespace/name
+func (c *Cluster) Partial(namespace, name string, i int) *corev1.ConfigMap {
+	c.t.Helper()
+	cm, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(combiner.PartialName(name, int64(i)), metav1.GetOptions{})
+	if err != nil {
+		c.t.Fatalf("failed to get chunk %d of megaconfigmap %s/%s: %v", i, namespace, name, err)
+	}
+	return cm
+}
+
+// CorruptChunk flips the first byte of the i-th chunk of the megaconfigmap namespace/name
+func (c *Cluster) CorruptChunk(namespace, name string, i int) {
+	c.t.Helper()
+	cm := c.Partial(namespace, name, i)
+	if data, ok := cm.Data[combiner.PartialItemKey]; ok && len(data) > 0 {
+		b := []byte(data)
+		b[0] ^= 0x01
+		cm.Data[combiner.PartialItemKey] = string(b)
+	} else if data := cm.BinaryData[combiner.PartialItemKey]; len(data) > 0 {
+		data[0] ^= 0x01
+	} else {
+		c.t.Fatalf("chunk %d of megaconfigmap %s/%s is empty", i, namespace, name)
+	}
+	if err := c.clientset.Tracker().Update(corev1.SchemeGroupVersion.WithResource("configmaps"), cm, namespace); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// DeleteChunk deletes the i-th chunk of the megaconfigmap namespace/name
+func (c *Cluster) DeleteChunk(namespace, name string, i int) {
+	c.t.Helper()
+	err := c.clientset.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("configmaps"), namespace, combiner.PartialName(name, int64(i)))
+	if err != nil {
+		c.t.Fatalf("failed to delete chunk %d of megaconfigmap %s/%s: %v", i, namespace, name, err)
+	}
+}
+
+// UpdateBefore replaces the content of the megaconfigmap namespace/name with data right before the next verb request
+// on the configmap object in namespace, e.g. "get" of a partial configmap while a combiner is reading the old version.
+// Empty object matches any configmap. The partial configmaps of the old version are deleted as kubectl megaconfigmap update does.
+func (c *Cluster) UpdateBefore(verb, object, namespace, name string, data []byte, opts ...client.Option) {
+	c.t.Helper()
+	current, err := client.New(c.clientset).Get(context.Background(), namespace, name)
+	if err != nil {
+		c.t.Fatalf("failed to get megaconfigmap %s/%s: %v", namespace, name, err)
+	}
+	// render the new version in a scratch API server, since reactors cannot call the clientset
+	scratch := fake.NewSimpleClientset()
+	_, err = client.New(scratch, opts...).Create(context.Background(), namespace, name, current.FileName, data)
+	if err != nil {
+		c.t.Fatalf("failed to render megaconfigmap %s/%s: %v", namespace, name, err)
+	}
+	rendered, err := scratch.CoreV1().ConfigMaps(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	done := false
+	c.clientset.PrependReactor(verb, "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if done || action.GetNamespace() != namespace {
+			return false, nil, nil
+		}
+		if get, ok := action.(k8stesting.GetAction); ok && len(object) > 0 && get.GetName() != object {
+			return false, nil, nil
+		}
+		done = true
+		gvr := corev1.SchemeGroupVersion.WithResource("configmaps")
+		tracker := c.clientset.Tracker()
+		old, err := tracker.List(gvr, corev1.SchemeGroupVersion.WithKind("ConfigMap"), namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		for _, cm := range old.(*corev1.ConfigMapList).Items {
+			if cm.Labels[combiner.IDLabel] == current.ID && cm.Labels[combiner.MasterLabel] != "true" {
+				if err := tracker.Delete(gvr, namespace, cm.Name); err != nil {
+					return true, nil, err
+				}
+			}
+		}
+		for i := range rendered.Items {
+			cm := rendered.Items[i].DeepCopy()
+			if cm.Name == name {
+				cm.UID = current.ConfigMap.UID
+				err = tracker.Update(gvr, cm, namespace)
+			} else {
+				for j := range cm.OwnerReferences {
+					cm.OwnerReferences[j].UID = current.ConfigMap.UID
+				}
+				err = tracker.Add(cm)
+			}
+			if err != nil {
+				return true, nil, err
+			}
+		}
+		return false, nil, nil
+	})
+}
+
+// Combine runs the combiner for the megaconfigmap namespace/name into a new directory.
+// The directory is removed by Cleanup.
+func (c *Cluster) Combine(namespace, name string, opts ...combiner.Option) (string, *combiner.Result, error) {
+	c.t.Helper()
+	dir, err := ioutil.TempDir("", "megaconfigmaptest")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.dirs = append(c.dirs, dir)
+	opts = append([]combiner.Option{combiner.WithClient(c.clientset), combiner.WithNamespace(namespace)}, opts...)
+	cb, err := combiner.NewCombiner(name, dir, opts...)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	result, err := cb.Run()
+	return dir, result, err
+}
+
+// AssertCombined combines the megaconfigmap namespace/name and fails the test unless the file holds want
+func (c *Cluster) AssertCombined(namespace, name string, want []byte, opts ...combiner.Option) *combiner.Result {
+	c.t.Helper()
+	_, result, err := c.Combine(namespace, name, opts...)
+	if err != nil {
+		c.t.Fatalf("failed to combine megaconfigmap %s/%s: %v", namespace, name, err)
+	}
+	AssertFile(c.t, result.Path, want)
+	return result
+}
+
+// AssertCombineFails combines the megaconfigmap namespace/name and fails the test unless it fails for reason
+func (c *Cluster) AssertCombineFails(namespace, name string, reason combiner.Reason, opts ...combiner.Option) {
+	c.t.Helper()
+	dir, _, err := c.Combine(namespace, name, opts...)
+	if err == nil {
+		c.t.Fatalf("combining megaconfigmap %s/%s succeeded, want %s", namespace, name, reason)
+	}
+	if actual := combiner.ReasonOf(err); actual != reason {
+		c.t.Fatalf("combining megaconfigmap %s/%s failed for %s, want %s: %v", namespace, name, actual, reason, err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if len(infos) > 0 {
+		c.t.Errorf("failed combiner left %s in the share directory", infos[0].Name())
+	}
+}
+
+// AssertFile fails the test unless the file at path holds want
+func AssertFile(t testing.TB, path string, want []byte) {
+	t.Helper()
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s holds %s, want %s", filepath.Base(path), summary(got), summary(want))
+	}
+}
+
+func summary(data []byte) string {
+	if len(data) <= 32 {
+		return string(data)
+	}
+	return fmt.Sprintf("%q... (%d bytes)", data[:32], len(data))
+}
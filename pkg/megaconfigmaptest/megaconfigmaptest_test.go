@@ -0,0 +1,100 @@
+package megaconfigmaptest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const testNamespace = "default"
+
+func TestRoundTrip(t *testing.T) {
+	binary := make([]byte, 1000)
+	for i := range binary {
+		binary[i] = byte(i * 7)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		opts []client.Option
+	}{
+		{name: "text", data: bytes.Repeat([]byte("0123456789"), 100), opts: []client.Option{client.WithChunkSize(64)}},
+		{name: "multi-byte", data: bytes.Repeat([]byte("あいうえお"), 50), opts: []client.Option{client.WithChunkSize(64)}},
+		{name: "binary", data: binary, opts: []client.Option{client.WithChunkSize(64)}},
+		{name: "gzip", data: bytes.Repeat([]byte("0123456789"), 1000), opts: []client.Option{client.WithChunkSize(16), client.WithCompression(combiner.EncodingGzip)}},
+		{name: "single chunk", data: []byte("abc")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := megaconfigmaptest.New(t)
+			defer c.Cleanup()
+			mcm := c.Create(testNamespace, "my-conf", "data", tt.data, tt.opts...)
+			result := c.AssertCombined(testNamespace, "my-conf", tt.data)
+			if result.ID != mcm.ID || result.Chunks != len(mcm.Manifest.Chunks) || result.Bytes != int64(len(tt.data)) {
+				t.Errorf("unexpected result: %+v", result)
+			}
+		})
+	}
+}
+
+func TestCorruptChunk(t *testing.T) {
+	for _, compression := range []string{"", combiner.EncodingGzip} {
+		compression := compression
+		t.Run("compression="+compression, func(t *testing.T) {
+			c := megaconfigmaptest.New(t)
+			defer c.Cleanup()
+			c.Create(testNamespace, "my-conf", "data", bytes.Repeat([]byte("0123456789"), 100),
+				client.WithChunkSize(64), client.WithCompression(compression))
+			c.CorruptChunk(testNamespace, "my-conf", 0)
+			c.AssertCombineFails(testNamespace, "my-conf", combiner.ReasonChecksumMismatch)
+		})
+	}
+}
+
+func TestDeleteChunk(t *testing.T) {
+	c := megaconfigmaptest.New(t)
+	defer c.Cleanup()
+	c.Create(testNamespace, "my-conf", "data", bytes.Repeat([]byte("0123456789"), 100), client.WithChunkSize(64))
+	c.DeleteChunk(testNamespace, "my-conf", 3)
+	c.AssertCombineFails(testNamespace, "my-conf", combiner.ReasonNotFound)
+}
+
+func TestUpdateBefore(t *testing.T) {
+	c := megaconfigmaptest.New(t)
+	defer c.Cleanup()
+	oldData := bytes.Repeat([]byte("0123456789"), 100)
+	newData := bytes.Repeat([]byte("abcdefghij"), 120)
+	c.Create(testNamespace, "my-conf", "data", oldData, client.WithChunkSize(64))
+
+	// the megaconfigmap is updated while the combiner is getting the partials
+	c.UpdateBefore("get", "my-conf-1", testNamespace, "my-conf", newData, client.WithChunkSize(100))
+	c.AssertCombineFails(testNamespace, "my-conf", combiner.ReasonInvalidConfigMap)
+	c.AssertCombined(testNamespace, "my-conf", newData)
+}
+
+func TestConfigMaps(t *testing.T) {
+	data := []byte("0123456789abc")
+	for name, cms := range map[string][]*corev1.ConfigMap{
+		"manifest": megaconfigmaptest.ConfigMaps(testNamespace, "my-conf", data, 1),
+		"legacy":   megaconfigmaptest.LegacyConfigMaps(testNamespace, "my-conf", data, 1),
+	} {
+		cms := cms
+		t.Run(name, func(t *testing.T) {
+			c := megaconfigmaptest.New(t)
+			defer c.Cleanup()
+			c.Put(cms...)
+			if got := c.Partial(testNamespace, "my-conf", 12).Labels[combiner.OrderLabel]; got != "12" {
+				t.Errorf("order of partial configmap 12 = %q", got)
+			}
+			result := c.AssertCombined(testNamespace, "my-conf", data)
+			if result.Chunks != 13 {
+				t.Errorf("combined %d chunks, want 13", result.Chunks)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package postrender
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	"github.com/dulltz/megaconfigmap/pkg/manifest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultThreshold is the default size of ConfigMap data to convert into megaconfigmaps
+const DefaultThreshold = 1000 * 1000
+
+const shareDir = "/megaconfigmap"
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Options configures Run
+type Options struct {
+	// Namespace is the namespace of objects without metadata.namespace. Empty means it is not known.
+	Namespace string
+	// Threshold is the size of ConfigMap data to convert
+	Threshold int
+	// Client renders megaconfigmaps
+	Client *client.Client
+	// Inject configures the combiner init containers
+	Inject *inject.Config
+}
+
+// Run reads a manifest stream from r and writes it to w, replacing ConfigMaps holding more than opts.Threshold bytes
+// with a megaconfigmap per key. Pod templates mounting them as volumes get an emptyDir volume of the same name
+// and a combiner init container per file, so the files appear at the same paths.
+// Other documents are written as they are.
+func Run(r io.Reader, w io.Writer, opts *Options) error {
+	docs, err := manifest.Read(r)
+	if err != nil {
+		return err
+	}
+
+	// converted keys by namespace/name of the ConfigMap
+	megaConfigMaps := make(map[string]map[string]string)
+	// ConfigMap and key by namespace/name of the megaconfigmap, since different keys can map to the same name
+	sources := make(map[string]string)
+	for _, doc := range docs {
+		u := &unstructured.Unstructured{Object: doc.Object}
+		if u.GetAPIVersion() != "v1" || u.GetKind() != "ConfigMap" {
+			continue
+		}
+		var cm corev1.ConfigMap
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(doc.Object, &cm); err != nil {
+			return err
+		}
+		if dataSize(&cm) <= opts.Threshold {
+			continue
+		}
+		namespace, err := opts.namespaceOf(u)
+		if err != nil {
+			return err
+		}
+		keys := make(map[string]string)
+		for _, key := range sortedKeys(&cm) {
+			name := megaConfigMapName(cm.Name, key)
+			source := fmt.Sprintf("key %s of ConfigMap %s", key, cm.Name)
+			if other, ok := sources[namespace+"/"+name]; ok {
+				return fmt.Errorf("%s and %s would both be converted to megaconfigmap %s; rename one of them", other, source, name)
+			}
+			sources[namespace+"/"+name] = source
+			data := []byte(cm.Data[key])
+			if b, ok := cm.BinaryData[key]; ok {
+				data = b
+			}
+			objects, err := opts.Client.Render(namespace, name, key, data)
+			if err != nil {
+				return fmt.Errorf("failed to convert key %s of ConfigMap %s; %w", key, cm.Name, err)
+			}
+			for _, obj := range objects {
+				// keep the namespace as rendered by helm
+				if len(cm.Namespace) == 0 {
+					obj.Namespace = ""
+				}
+				converted, err := client.Unstructured(obj)
+				if err != nil {
+					return err
+				}
+				doc.Replacements = append(doc.Replacements, converted)
+			}
+			keys[key] = name
+		}
+		megaConfigMaps[namespace+"/"+cm.Name] = keys
+	}
+	if len(megaConfigMaps) == 0 {
+		return manifest.Write(w, docs)
+	}
+
+	for _, doc := range docs {
+		u := &unstructured.Unstructured{Object: doc.Object}
+		namespace := u.GetNamespace()
+		if len(namespace) == 0 {
+			namespace = opts.Namespace
+		}
+		doc.Changed, err = inject.UpdatePodSpec(doc.Object, func(spec *corev1.PodSpec) (bool, error) {
+			return opts.patchPodSpec(spec, func(name string) map[string]string {
+				return megaConfigMaps[namespace+"/"+name]
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to patch %s %s; %w", u.GetKind(), u.GetName(), err)
+		}
+	}
+	return manifest.Write(w, docs)
+}
+
+func (o *Options) namespaceOf(u *unstructured.Unstructured) (string, error) {
+	if ns := u.GetNamespace(); len(ns) > 0 {
+		return ns, nil
+	}
+	if len(o.Namespace) == 0 {
+		return "", fmt.Errorf("%s %s has no namespace; specify --namespace, since the megaconfigmap ID depends on it", u.GetKind(), u.GetName())
+	}
+	return o.Namespace, nil
+}
+
+// patchPodSpec replaces configMap volumes converted to megaconfigmaps in spec
+func (o *Options) patchPodSpec(spec *corev1.PodSpec, converted func(string) map[string]string) (bool, error) {
+	changed := false
+	for i := range spec.Volumes {
+		v := &spec.Volumes[i]
+		if v.ConfigMap == nil {
+			continue
+		}
+		keys := converted(v.ConfigMap.Name)
+		if keys == nil {
+			continue
+		}
+		if v.ConfigMap.Optional != nil && *v.ConfigMap.Optional {
+			// the combiner fails without the megaconfigmap, so the pod would not start
+			return false, fmt.Errorf("optional ConfigMap %s in volume %s cannot be converted", v.ConfigMap.Name, v.Name)
+		}
+		items := v.ConfigMap.Items
+		if len(items) == 0 {
+			for key := range keys {
+				items = append(items, corev1.KeyToPath{Key: key, Path: key})
+			}
+			sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+		}
+		for j, item := range items {
+			name, ok := keys[item.Key]
+			if !ok {
+				return false, fmt.Errorf("key %s is not found in ConfigMap %s", item.Key, v.ConfigMap.Name)
+			}
+			mount := corev1.VolumeMount{Name: v.Name, MountPath: shareDir}
+			if dir := path.Dir(item.Path); dir != "." {
+				mount.SubPath = dir
+			}
+			container := inject.CombinerContainer(inject.Name(fmt.Sprintf("%s-%d", v.Name, j)), name, path.Base(item.Path), mount, o.Inject)
+			mode := item.Mode
+			if mode == nil {
+				mode = v.ConfigMap.DefaultMode
+			}
+			if mode != nil {
+				container.Args = append(container.Args, fmt.Sprintf("-file-mode=%#o", *mode))
+			}
+			spec.InitContainers = append(spec.InitContainers, container)
+		}
+		v.VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		changed = true
+	}
+	if err := checkOtherReferences(spec, converted); err != nil {
+		return false, err
+	}
+	if !changed {
+		return false, nil
+	}
+	if len(o.Inject.ServiceAccountName) > 0 && (len(spec.ServiceAccountName) == 0 || spec.ServiceAccountName == "default") {
+		spec.ServiceAccountName = o.Inject.ServiceAccountName
+	}
+	return true, nil
+}
+
+// checkOtherReferences returns an error if converted ConfigMaps are referred to other than by volumes,
+// since megaconfigmaps cannot be used for them
+func checkOtherReferences(spec *corev1.PodSpec, converted func(string) map[string]string) error {
+	for _, v := range spec.Volumes {
+		if v.Projected == nil {
+			continue
+		}
+		for _, source := range v.Projected.Sources {
+			if source.ConfigMap != nil && converted(source.ConfigMap.Name) != nil {
+				return fmt.Errorf("ConfigMap %s in projected volume %s cannot be converted", source.ConfigMap.Name, v.Name)
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.EnvFrom {
+			if env.ConfigMapRef != nil && converted(env.ConfigMapRef.Name) != nil {
+				return fmt.Errorf("ConfigMap %s in envFrom of container %s cannot be converted", env.ConfigMapRef.Name, c.Name)
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && converted(env.ValueFrom.ConfigMapKeyRef.Name) != nil {
+				return fmt.Errorf("ConfigMap %s in env %s of container %s cannot be converted", env.ValueFrom.ConfigMapKeyRef.Name, env.Name, c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// megaConfigMapName returns the name of the megaconfigmap for key of the ConfigMap
+func megaConfigMapName(configMapName, key string) string {
+	name := configMapName + "-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(key), "-"), "-")
+	// leave room for the suffix of partial configmaps
+	if len(name) <= validation.DNS1123LabelMaxLength-8 {
+		return name
+	}
+	sum := fmt.Sprintf("%x", sha1.Sum([]byte(configMapName+"/"+key)))[:10]
+	return name[:validation.DNS1123LabelMaxLength-8-len(sum)-1] + "-" + sum
+}
+
+func dataSize(cm *corev1.ConfigMap) int {
+	size := 0
+	for _, v := range cm.Data {
+		size += len(v)
+	}
+	for _, v := range cm.BinaryData {
+		size += len(v)
+	}
+	return size
+}
+
+func sortedKeys(cm *corev1.ConfigMap) []string {
+	var keys []string
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	for key := range cm.BinaryData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
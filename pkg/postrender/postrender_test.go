@@ -0,0 +1,253 @@
+package postrender
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const input = `# Source: chart/templates/small.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: small
+data:
+  a: "1"
+---
+# Source: chart/templates/large.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: large
+data:
+  app.conf: "abcdefghijklmnopqrstuvwxyz"
+binaryData:
+  model.bin: //79/A==
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: main
+        volumeMounts:
+        - name: config
+          mountPath: /etc/app
+      volumes:
+      - name: config
+        configMap:
+          name: large
+          defaultMode: 420
+          items:
+          - key: app.conf
+            path: conf/app.conf
+            mode: 493
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: pod
+spec:
+  containers:
+  - name: main
+    volumeMounts:
+    - name: config
+      mountPath: /etc/app
+  volumes:
+  - name: config
+    configMap:
+      name: large
+      defaultMode: 420
+  - name: small
+    configMap:
+      name: small
+`
+
+func newOptions() *Options {
+	return &Options{
+		Namespace: "default",
+		Threshold: 16,
+		Client:    client.New(nil, client.WithChunkSize(8)),
+		Inject:    &inject.Config{},
+	}
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	err := Run(strings.NewReader(input), &out, newOptions())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "---\n# Source: chart/templates/small.yaml\n") {
+		t.Errorf("small ConfigMap is not kept as is: %s", out.String())
+	}
+
+	k8s := fake.NewSimpleClientset()
+	var deployment appsv1.Deployment
+	var pod corev1.Pod
+	reader := utilyaml.NewYAMLOrJSONDecoder(&out, 4096)
+	for {
+		var obj map[string]interface{}
+		if err := reader.Decode(&obj); err != nil {
+			break
+		}
+		switch obj["kind"] {
+		case "ConfigMap":
+			var cm corev1.ConfigMap
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &cm); err != nil {
+				t.Fatal(err)
+			}
+			if cm.Name == "large" {
+				t.Error("large ConfigMap is not converted")
+			}
+			if _, err := k8s.CoreV1().ConfigMaps("default").Create(&cm); err != nil {
+				t.Fatal(err)
+			}
+		case "Deployment":
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &deployment); err != nil {
+				t.Fatal(err)
+			}
+		case "Pod":
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &pod); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for name, want := range map[string][]byte{
+		"large-app-conf":  []byte("abcdefghijklmnopqrstuvwxyz"),
+		"large-model-bin": {0xff, 0xfe, 0xfd, 0xfc},
+	} {
+		dir, err := ioutil.TempDir("", "postrender")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cb, err := combiner.NewCombiner(name, dir, combiner.WithClient(k8s), combiner.WithNamespace("default"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := cb.Run()
+		if err != nil {
+			t.Fatalf("failed to combine %s: %v", name, err)
+		}
+		got, err := ioutil.ReadFile(result.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+
+	spec := deployment.Spec.Template.Spec
+	if spec.Volumes[0].EmptyDir == nil || len(spec.InitContainers) != 1 {
+		t.Fatalf("deployment is not patched: %+v", spec)
+	}
+	c := spec.InitContainers[0]
+	if c.Args[0] != "-megaconfigmap=large-app-conf" || c.Args[2] != "-file-name=app.conf" || c.Args[3] != "-file-mode=0755" || c.VolumeMounts[0].SubPath != "conf" {
+		t.Errorf("unexpected init container: %+v", c)
+	}
+	if spec.Containers[0].VolumeMounts[0].Name != "config" {
+		t.Errorf("volume mounts of the containers are changed: %+v", spec.Containers[0].VolumeMounts)
+	}
+
+	spec = pod.Spec
+	if spec.Volumes[0].EmptyDir == nil || spec.Volumes[1].ConfigMap == nil || len(spec.InitContainers) != 2 {
+		t.Fatalf("pod is not patched: %+v", spec)
+	}
+	if spec.InitContainers[0].Args[0] != "-megaconfigmap=large-app-conf" || spec.InitContainers[1].Args[0] != "-megaconfigmap=large-model-bin" {
+		t.Errorf("unexpected init containers: %+v", spec.InitContainers)
+	}
+	for _, c := range spec.InitContainers {
+		if c.Args[len(c.Args)-1] != "-file-mode=0644" {
+			t.Errorf("defaultMode is not carried over: %v", c.Args)
+		}
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	opts := newOptions()
+	opts.Namespace = ""
+	if err := Run(strings.NewReader(input), ioutil.Discard, opts); err == nil {
+		t.Error("Run() should fail without namespace")
+	}
+
+	envFrom := input + `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: env
+spec:
+  containers:
+  - name: main
+    envFrom:
+    - configMapRef:
+        name: large
+`
+	if err := Run(strings.NewReader(envFrom), ioutil.Discard, newOptions()); err == nil {
+		t.Error("Run() should fail for ConfigMaps referred to by envFrom")
+	}
+
+	optional := input + `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: optional
+spec:
+  containers:
+  - name: main
+  volumes:
+  - name: config
+    configMap:
+      name: large
+      optional: true
+`
+	if err := Run(strings.NewReader(optional), ioutil.Discard, newOptions()); err == nil {
+		t.Error("Run() should fail for optional ConfigMap volumes")
+	}
+
+	opts = newOptions()
+	opts.Threshold = 0
+	collision := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: conf
+data:
+  Model.bin: a
+  model.bin: b
+`
+	if err := Run(strings.NewReader(collision), ioutil.Discard, opts); err == nil || !strings.Contains(err.Error(), "conf-model-bin") {
+		t.Errorf("Run() error = %v, want a collision of keys", err)
+	}
+}
+
+func TestMegaConfigMapName(t *testing.T) {
+	if name := megaConfigMapName("large", "App_Config.YAML"); name != "large-app-config-yaml" {
+		t.Errorf("megaConfigMapName() = %s", name)
+	}
+	long := megaConfigMapName(strings.Repeat("a", 60), "key")
+	if len(long) > 55 || long == megaConfigMapName(strings.Repeat("a", 60), "other") {
+		t.Errorf("megaConfigMapName() = %s", long)
+	}
+}
@@ -0,0 +1,87 @@
+// Package rbac generates least-privilege RBAC for combiners of a megaconfigmap.
+package rbac
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RoleName returns the name of the Role and the RoleBinding for the megaconfigmap
+func RoleName(megaConfigMapName string) string {
+	return inject.Name(megaConfigMapName)
+}
+
+// Role returns the Role allowing combiners to read only mcm.
+// It gets the megaconfigmap and its partial configmaps by name, and lists and watches the megaconfigmap for --watch.
+func Role(mcm *client.MegaConfigMap) (*rbacv1.Role, error) {
+	if mcm.Manifest == nil {
+		return nil, fmt.Errorf("megaconfigmap %s has no manifest, so its combiners need to list configmaps; update it to add the manifest", mcm.Name)
+	}
+	names := []string{mcm.Name}
+	for _, chunk := range mcm.Manifest.Chunks {
+		names = append(names, chunk.Name)
+	}
+	role := &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: mcm.Namespace,
+			Name:      RoleName(mcm.Name),
+		},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}, ResourceNames: names},
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list", "watch"}, ResourceNames: []string{mcm.Name}},
+			{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create"}},
+		},
+	}
+	if len(validation.IsValidLabelValue(mcm.Name)) == 0 {
+		role.Labels = map[string]string{combiner.NameLabel: mcm.Name}
+	}
+	return role, nil
+}
+
+// RoleBinding returns the RoleBinding of the Role for mcm to the service account in the namespace of mcm
+func RoleBinding(mcm *client.MegaConfigMap, serviceAccount string) *rbacv1.RoleBinding {
+	name := RoleName(mcm.Name)
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: mcm.Namespace,
+			Name:      name + "-" + serviceAccount,
+		},
+		RoleRef:  rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: name},
+		Subjects: []rbacv1.Subject{{Kind: "ServiceAccount", Namespace: mcm.Namespace, Name: serviceAccount}},
+	}
+}
+
+// Refresh updates the rules of the Role for mcm if it exists, since the names of the partial configmaps change on updates.
+// It returns false if the Role does not exist or is up to date.
+func Refresh(k8s kubernetes.Interface, mcm *client.MegaConfigMap) (bool, error) {
+	want, err := Role(mcm)
+	if err != nil {
+		return false, err
+	}
+	roles := k8s.RbacV1().Roles(mcm.Namespace)
+	current, err := roles.Get(want.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current.Labels[combiner.NameLabel] != mcm.Name || reflect.DeepEqual(current.Rules, want.Rules) {
+		return false, nil
+	}
+	current = current.DeepCopy()
+	current.Rules = want.Rules
+	_, err = roles.Update(current)
+	return err == nil, err
+}
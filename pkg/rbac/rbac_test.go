@@ -0,0 +1,84 @@
+package rbac
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/client"
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	"github.com/dulltz/megaconfigmap/pkg/megaconfigmaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRole(t *testing.T) {
+	c := megaconfigmaptest.New(t)
+	defer c.Cleanup()
+	mcm := c.Create("default", "my-conf", "conf.txt", []byte("0123456789"), client.WithChunkSize(4))
+
+	role, err := Role(mcm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Name != "megaconfigmap-my-conf" || role.Labels[combiner.NameLabel] != "my-conf" {
+		t.Errorf("unexpected metadata: %+v", role.ObjectMeta)
+	}
+	want := []string{"my-conf", "my-conf-0", "my-conf-1", "my-conf-2"}
+	if !reflect.DeepEqual(role.Rules[0].ResourceNames, want) {
+		t.Errorf("get is allowed for %v, want %v", role.Rules[0].ResourceNames, want)
+	}
+	for _, rule := range role.Rules {
+		if rule.Resources[0] == "configmaps" && len(rule.ResourceNames) == 0 {
+			t.Errorf("rule is not scoped: %+v", rule)
+		}
+	}
+
+	binding := RoleBinding(mcm, "my-app")
+	if binding.RoleRef.Name != role.Name || binding.Subjects[0].Name != "my-app" || binding.Subjects[0].Namespace != "default" {
+		t.Errorf("unexpected binding: %+v", binding)
+	}
+
+	mcm.Manifest = nil
+	if _, err := Role(mcm); err == nil {
+		t.Error("Role() should fail without the manifest")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	c := megaconfigmaptest.New(t)
+	defer c.Cleanup()
+	mcm := c.Create("default", "my-conf", "conf.txt", []byte("0123456789"), client.WithChunkSize(4))
+
+	refreshed, err := Refresh(c.Client(), mcm)
+	if err != nil || refreshed {
+		t.Fatalf("Refresh() without the role = %v, %v", refreshed, err)
+	}
+
+	role, err := Role(mcm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := c.Client().RbacV1().Roles("default")
+	if _, err := roles.Create(role); err != nil {
+		t.Fatal(err)
+	}
+	mcm, _, err = client.New(c.Client(), client.WithChunkSize(4)).Apply(context.Background(), "default", "my-conf", "conf.txt", []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err = Refresh(c.Client(), mcm)
+	if err != nil || !refreshed {
+		t.Fatalf("Refresh() = %v, %v", refreshed, err)
+	}
+	updated, err := roles.Get(role.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Rules[0].ResourceNames) != 1+len(mcm.Manifest.Chunks) {
+		t.Errorf("role is not updated: %v", updated.Rules[0].ResourceNames)
+	}
+	refreshed, err = Refresh(c.Client(), mcm)
+	if err != nil || refreshed {
+		t.Errorf("second Refresh() = %v, %v", refreshed, err)
+	}
+}
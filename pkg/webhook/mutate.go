@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Mutator injects combiners into pods annotated with inject.InjectAnnotation
+type Mutator struct {
+	Config *inject.Config
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ServeHTTP handles AdmissionReview requests for pods
+func (m *Mutator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, m.admit)
+}
+
+func (m *Mutator) admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return allowed()
+	}
+	var pod corev1.Pod
+	err := json.Unmarshal(req.Object.Raw, &pod)
+	if err != nil {
+		return denied(http.StatusBadRequest, "failed to decode pod: %v", err)
+	}
+	value, ok := pod.Annotations[inject.InjectAnnotation]
+	if !ok {
+		return allowed()
+	}
+	targets, err := inject.ParseTargets(value)
+	if err != nil {
+		return denied(http.StatusUnprocessableEntity, "%v", err)
+	}
+
+	spec := pod.Spec.DeepCopy()
+	err = inject.PodSpec(spec, targets, inject.ParseContainers(pod.Annotations[inject.ContainersAnnotation]), m.Config)
+	if err != nil {
+		return denied(http.StatusUnprocessableEntity, "%v", err)
+	}
+	patch := specPatch(&pod.Spec, spec)
+	if len(patch) == 0 {
+		return allowed()
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return denied(http.StatusInternalServerError, "%v", err)
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     data,
+		PatchType: &patchType,
+	}
+}
+
+// specPatch returns JSON patch operations to turn the fields of old that the injection touches into new.
+// "add" replaces an existing member, so it is used for both new and existing fields.
+func specPatch(old, new *corev1.PodSpec) []patchOperation {
+	var patch []patchOperation
+	if !reflect.DeepEqual(old.InitContainers, new.InitContainers) {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/initContainers", Value: new.InitContainers})
+	}
+	if !reflect.DeepEqual(old.Containers, new.Containers) {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/containers", Value: new.Containers})
+	}
+	if !reflect.DeepEqual(old.Volumes, new.Volumes) {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/volumes", Value: new.Volumes})
+	}
+	if old.ServiceAccountName != new.ServiceAccountName {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/serviceAccountName", Value: new.ServiceAccountName})
+	}
+	return patch
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/inject"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func review(t *testing.T, handler http.Handler, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  req,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var res admissionv1.AdmissionReview
+	err = json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Response.UID != req.UID {
+		t.Errorf("UID = %v, want %v", res.Response.UID, req.UID)
+	}
+	return res.Response
+}
+
+func podRequest(t *testing.T, pod *corev1.Pod) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestMutator(t *testing.T) {
+	m := &Mutator{Config: &inject.Config{}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{inject.InjectAnnotation: "my-conf:/etc/app/model.bin"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+	}
+
+	res := review(t, m, podRequest(t, pod))
+	if !res.Allowed || res.PatchType == nil {
+		t.Fatalf("unexpected response: %v", res)
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(res.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 3 {
+		t.Errorf("unexpected patch: %s", res.Patch)
+	}
+
+	err := inject.PodSpec(&pod.Spec, []inject.Target{{MegaConfigMap: "my-conf", Path: "/etc/app/model.bin"}}, nil, m.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = review(t, m, podRequest(t, pod))
+	if !res.Allowed || len(res.Patch) != 0 {
+		t.Errorf("re-invocation should not patch: %s", res.Patch)
+	}
+
+	pod.Annotations[inject.InjectAnnotation] = "my-conf"
+	res = review(t, m, podRequest(t, pod))
+	if res.Allowed {
+		t.Error("invalid annotation should be denied")
+	}
+}
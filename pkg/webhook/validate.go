@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultFieldManagers are the field managers allowed to modify megaconfigmaps by default
+	DefaultFieldManagers = "kubectl-megaconfigmap"
+	// DefaultUsers are the users allowed to modify and delete megaconfigmaps by default:
+	// the garbage collector and the service account of the controller
+	DefaultUsers = "system:serviceaccount:kube-system:generic-garbage-collector,system:serviceaccount:megaconfigmap-system:megaconfigmap-controller"
+)
+
+// Validator protects megaconfigmaps and partial configmaps from accidental edits and deletes.
+// Updates are allowed only from FieldManagers or Users, and deletes only from Users or of objects marked
+// with combiner.DeletionRequestedAnnotation. combiner.BreakGlassAnnotation bypasses the checks.
+// It also rejects megaconfigmaps with malformed manifests.
+//
+// It is not an authorization boundary: the field manager is chosen by the client, and anyone allowed to update
+// a configmap can set the annotations. Users, authenticated by the API server, are the only trusted bypass.
+// Restrict who can write configmaps by RBAC.
+type Validator struct {
+	FieldManagers map[string]bool
+	Users         map[string]bool
+}
+
+// ServeHTTP handles AdmissionReview requests for configmaps
+func (v *Validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, v.admit)
+}
+
+func (v *Validator) admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != "ConfigMap" {
+		return allowed()
+	}
+	var obj, oldObj corev1.ConfigMap
+	if len(req.Object.Raw) > 0 {
+		if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+			return denied(http.StatusBadRequest, "failed to decode configmap: %v", err)
+		}
+	}
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldObj); err != nil {
+			return denied(http.StatusBadRequest, "failed to decode configmap: %v", err)
+		}
+	}
+
+	switch req.Operation {
+	case admissionv1.Create:
+		return validateManifest(&obj)
+	case admissionv1.Update:
+		if !isMegaConfigMap(&oldObj) {
+			return validateManifest(&obj)
+		}
+		if breakGlass(&obj) || v.Users[req.UserInfo.Username] || v.FieldManagers[fieldManager(req)] {
+			return validateManifest(&obj)
+		}
+		return denied(http.StatusForbidden,
+			"%s is a part of megaconfigmap; modify it by kubectl megaconfigmap or set the %s annotation",
+			req.Name, combiner.BreakGlassAnnotation)
+	case admissionv1.Delete:
+		if !isMegaConfigMap(&oldObj) {
+			return allowed()
+		}
+		if breakGlass(&oldObj) || v.Users[req.UserInfo.Username] || len(oldObj.Annotations[combiner.DeletionRequestedAnnotation]) > 0 {
+			return allowed()
+		}
+		return denied(http.StatusForbidden,
+			"%s is a part of megaconfigmap; delete it by kubectl megaconfigmap delete or set the %s annotation",
+			req.Name, combiner.BreakGlassAnnotation)
+	}
+	return allowed()
+}
+
+func isMegaConfigMap(cm *corev1.ConfigMap) bool {
+	_, ok := cm.Labels[combiner.IDLabel]
+	return ok
+}
+
+func breakGlass(cm *corev1.ConfigMap) bool {
+	return cm.Annotations[combiner.BreakGlassAnnotation] == "true"
+}
+
+func validateManifest(cm *corev1.ConfigMap) *admissionv1.AdmissionResponse {
+	if cm.Labels[combiner.MasterLabel] != "true" {
+		return allowed()
+	}
+	if _, err := combiner.ManifestOf(cm); err != nil {
+		return denied(http.StatusUnprocessableEntity, "%v", err)
+	}
+	return allowed()
+}
+
+// fieldManager returns the field manager of a create or update request
+func fieldManager(req *admissionv1.AdmissionRequest) string {
+	var opts struct {
+		FieldManager string `json:"fieldManager"`
+	}
+	if len(req.Options.Raw) == 0 || json.Unmarshal(req.Options.Raw, &opts) != nil {
+		return ""
+	}
+	return opts.FieldManager
+}
@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dulltz/megaconfigmap/pkg/combiner"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func configMapRequest(t *testing.T, op admissionv1.Operation, obj, oldObj *corev1.ConfigMap, user, manager string) *admissionv1.AdmissionRequest {
+	req := &admissionv1.AdmissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		Operation: op,
+		UserInfo:  authenticationv1.UserInfo{Username: user},
+	}
+	for _, o := range []struct {
+		cm  *corev1.ConfigMap
+		raw *runtime.RawExtension
+	}{{obj, &req.Object}, {oldObj, &req.OldObject}} {
+		if o.cm == nil {
+			continue
+		}
+		data, err := json.Marshal(o.cm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.raw.Raw = data
+	}
+	if len(manager) > 0 {
+		data, err := json.Marshal(&metav1.UpdateOptions{FieldManager: manager})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Options.Raw = data
+	}
+	return req
+}
+
+func TestValidator(t *testing.T) {
+	manifest, err := combiner.NewManifest([]byte("abc"), "my-conf", 2).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-conf-0", Labels: map[string]string{combiner.IDLabel: "id", combiner.OrderLabel: "0"}},
+		Data:       map[string]string{combiner.PartialItemKey: "ab"},
+	}
+	tampered := partial.DeepCopy()
+	tampered.Labels[combiner.OrderLabel] = "1"
+	breakGlassed := tampered.DeepCopy()
+	breakGlassed.Annotations = map[string]string{combiner.BreakGlassAnnotation: "true"}
+	marked := partial.DeepCopy()
+	marked.Annotations = map[string]string{combiner.DeletionRequestedAnnotation: "kubectl-megaconfigmap"}
+	master := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-conf", Labels: map[string]string{combiner.IDLabel: "id", combiner.MasterLabel: "true"}},
+		Data:       map[string]string{combiner.ManifestKey: manifest},
+	}
+	malformed := master.DeepCopy()
+	malformed.Data[combiner.ManifestKey] = "{"
+	plain := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "plain"}}
+
+	v := &Validator{
+		FieldManagers: map[string]bool{"kubectl-megaconfigmap": true},
+		Users:         map[string]bool{"system:serviceaccount:kube-system:generic-garbage-collector": true},
+	}
+	tests := []struct {
+		name string
+		req  *admissionv1.AdmissionRequest
+		want bool
+	}{
+		{"create master", configMapRequest(t, admissionv1.Create, master, nil, "alice", ""), true},
+		{"create malformed master", configMapRequest(t, admissionv1.Create, malformed, nil, "alice", ""), false},
+		{"update malformed master by plugin", configMapRequest(t, admissionv1.Update, malformed, master, "alice", "kubectl-megaconfigmap"), false},
+		{"update partial by kubectl edit", configMapRequest(t, admissionv1.Update, tampered, partial, "alice", "kubectl-edit"), false},
+		{"update partial by plugin", configMapRequest(t, admissionv1.Update, tampered, partial, "alice", "kubectl-megaconfigmap"), true},
+		{"update partial with break-glass", configMapRequest(t, admissionv1.Update, breakGlassed, partial, "alice", "kubectl-edit"), true},
+		{"update plain configmap", configMapRequest(t, admissionv1.Update, plain, plain, "alice", ""), true},
+		{"delete partial", configMapRequest(t, admissionv1.Delete, nil, partial, "alice", ""), false},
+		{"delete partial by GC", configMapRequest(t, admissionv1.Delete, nil, partial, "system:serviceaccount:kube-system:generic-garbage-collector", ""), true},
+		{"delete marked partial", configMapRequest(t, admissionv1.Delete, nil, marked, "alice", ""), true},
+		{"delete break-glassed partial", configMapRequest(t, admissionv1.Delete, nil, breakGlassed, "alice", ""), true},
+		{"delete plain configmap", configMapRequest(t, admissionv1.Delete, nil, plain, "alice", ""), true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			res := review(t, v, tt.req)
+			if res.Allowed != tt.want {
+				t.Errorf("Allowed = %v, want %v: %v", res.Allowed, tt.want, res.Result)
+			}
+		})
+	}
+}